@@ -0,0 +1,223 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	defaultBatchMaxSize  = 100
+	defaultBatchMaxBytes = 1024 * 1024
+	defaultBatchLinger   = time.Second
+)
+
+// KafkaBatchHandler handles the messages of a batch at once. When only some messages of the batch can't be handled, it should
+// return a KafkaBatchError, otherwise all the messages of the batch are considered as failed
+type KafkaBatchHandler func(ctx context.Context, messages []KafkaMessage) error
+
+// KafkaBatchError is returned by a batch handler to report the messages which can't be handled, by index in the batch. Only these
+// messages are retried or sent to the failure topic
+type KafkaBatchError struct {
+	Failures map[int]error
+}
+
+func (e *KafkaBatchError) Error() string {
+	return fmt.Sprintf("failed to handle %d messages of the batch", len(e.Failures))
+}
+
+type batchPolicy struct {
+	maxSize  int
+	maxBytes int
+	linger   time.Duration
+}
+
+func newBatchPolicy(batchRep *KafkaBatchRepresentation) batchPolicy {
+	var policy = batchPolicy{
+		maxSize:  defaultBatchMaxSize,
+		maxBytes: defaultBatchMaxBytes,
+		linger:   defaultBatchLinger,
+	}
+	if batchRep == nil {
+		return policy
+	}
+	if batchRep.MaxSize != nil {
+		policy.maxSize = *batchRep.MaxSize
+	}
+	if batchRep.MaxBytes != nil {
+		policy.maxBytes = *batchRep.MaxBytes
+	}
+	if batchRep.Linger != nil {
+		policy.linger = *batchRep.Linger
+	}
+	return policy
+}
+
+// messageBatch accumulates the messages of a claim until they are handled
+type messageBatch struct {
+	messages []*consumedMessage
+	bytes    int
+	// last is the last consumed message, including the ones which could not be mapped and are not part of the batch
+	last *sarama.ConsumerMessage
+}
+
+// consumeClaimInBatches accumulates the messages of the claim and invokes the batch handler once a threshold of the batch policy
+// is reached. The offset of the last message of a batch is marked once the whole batch is processed
+func (c *consumer) consumeClaimInBatches(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var messages = claim.Messages()
+	var batch = &messageBatch{}
+	var linger = time.NewTimer(c.batch.linger)
+	linger.Stop()
+	defer linger.Stop()
+	for {
+		select {
+		case <-session.Context().Done():
+			// Session is ending (rebalance or stop): the pending batch will be consumed again by the next session
+			return nil
+		case <-linger.C:
+			if err := c.flushBatch(session, claim, batch); err != nil {
+				return err
+			}
+			batch = &messageBatch{}
+			continue
+		case kafkaMsg, ok := <-messages:
+			if !ok {
+				return c.flushBatch(session, claim, batch)
+			}
+			if batch.last == nil {
+				linger.Reset(c.batch.linger)
+			}
+			ctx := c.contextInit(context.Background())
+			if !c.delayConsumption(ctx, session, kafkaMsg) {
+				// The pending batch will be consumed again by the next session
+				return nil
+			}
+			c.addToBatch(ctx, session, batch, kafkaMsg)
+		}
+
+		if len(batch.messages) >= c.batch.maxSize || batch.bytes >= c.batch.maxBytes {
+			linger.Stop()
+			if err := c.flushBatch(session, claim, batch); err != nil {
+				return err
+			}
+			batch = &messageBatch{}
+		}
+	}
+}
+
+// addToBatch maps a consumed message and adds it to the batch. Messages which can't be mapped are sent to the failure topic
+func (c *consumer) addToBatch(ctx context.Context, session sarama.ConsumerGroupSession, batch *messageBatch, kafkaMsg *sarama.ConsumerMessage) {
+	batch.last = kafkaMsg
+	var content, err = c.applyMappers(ctx, kafkaMsg)
+	var msg = &consumedMessage{
+		msg:              kafkaMsg,
+		content:          content,
+		consumer:         c,
+		session:          session,
+		previousAttempts: retriedAttempts(kafkaMsg),
+	}
+	if err != nil {
+		msg.SendToFailureTopicWithError(err)
+		return
+	}
+	batch.messages = append(batch.messages, msg)
+	batch.bytes += len(kafkaMsg.Key) + len(kafkaMsg.Value)
+}
+
+// flushBatch handles the messages of the batch and commits the batch if auto-commit is enabled
+func (c *consumer) flushBatch(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, batch *messageBatch) error {
+	if batch.last == nil {
+		return nil
+	}
+	if len(batch.messages) > 0 {
+		if !c.waitWhilePaused(session.Context(), batch.last.Partition) {
+			// Session is ending while the partition is paused: the batch will be consumed again by the next session
+			return nil
+		}
+		var ctx = c.contextInit(context.Background())
+		if err := c.handleBatch(ctx, session, batch.messages); err != nil {
+			if errors.Is(err, errSessionEnded) {
+				return nil
+			}
+			return err
+		}
+		var first, last = batch.messages[0].msg.Offset, batch.messages[len(batch.messages)-1].msg.Offset
+		if first/c.logEventRate != last/c.logEventRate || first%c.logEventRate == 0 {
+			logMsg := fmt.Sprintf("Messages from %d to %d offset are processed", first, last)
+			c.logger.Info(ctx, "msg", logMsg, "topic", c.topic, "partition", batch.last.Partition, "topic", claim.Topic())
+		}
+	}
+
+	// Commit batch
+	if c.autoCommit {
+		session.MarkMessage(batch.last, "")
+	}
+	return nil
+}
+
+// handleBatch invokes the batch handler and retries the failed messages according to the retry policy of the consumer. Messages which
+// still fail are sent to the next retry stage or to the failure topic
+func (c *consumer) handleBatch(ctx context.Context, session sarama.ConsumerGroupSession, messages []*consumedMessage) error {
+	var pending = messages
+	for {
+		var batch = make([]KafkaMessage, len(pending))
+		for idx, msg := range pending {
+			msg.attempt++
+			batch[idx] = msg
+		}
+		var err = c.batchHandler(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		var failures = map[int]error{}
+		var batchErr *KafkaBatchError
+		if errors.As(err, &batchErr) {
+			failures = batchErr.Failures
+		} else {
+			for idx := range pending {
+				failures[idx] = err
+			}
+		}
+		c.logger.Error(ctx, "msg", "Failed to handle batch", "err", err.Error(), "topic", c.topic, "size", len(pending), "failures", len(failures))
+		if slices.ContainsFunc(pending, func(msg *consumedMessage) bool { return msg.abort }) {
+			return err
+		}
+		if session.Context().Err() != nil {
+			// Session ended while retrying: the batch will be consumed again by the next session
+			return errSessionEnded
+		}
+
+		var retried []*consumedMessage
+		for _, idx := range slices.Sorted(maps.Keys(failures)) {
+			if idx < 0 || idx >= len(pending) {
+				continue
+			}
+			var msg, cause = pending[idx], failures[idx]
+			if c.retry != nil && msg.attempt < c.retry.maxAttempts && c.retryable(cause) {
+				retried = append(retried, msg)
+				continue
+			}
+			// In-process retries are exhausted: nothing is sent when neither a retry stage nor a failure producer is configured
+			if err = c.routeFailure(ctx, session, msg, cause); err != nil {
+				return err
+			}
+		}
+		if len(retried) == 0 {
+			return nil
+		}
+
+		var backoff = c.retry.backoff(retried[0].attempt)
+		c.logger.Warn(ctx, "msg", "Failed to handle batch. Retry", "topic", c.topic, "size", len(retried), "attempt", retried[0].attempt, "backoff", backoff)
+		select {
+		case <-session.Context().Done():
+			return errSessionEnded
+		case <-time.After(backoff):
+		}
+		pending = retried
+	}
+}
@@ -0,0 +1,163 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewBatchPolicy(t *testing.T) {
+	assert.Equal(t, batchPolicy{maxSize: defaultBatchMaxSize, maxBytes: defaultBatchMaxBytes, linger: defaultBatchLinger}, newBatchPolicy(nil))
+	assert.Equal(t, batchPolicy{maxSize: 10, maxBytes: defaultBatchMaxBytes, linger: time.Millisecond},
+		newBatchPolicy(&KafkaBatchRepresentation{MaxSize: new(10), Linger: new(time.Millisecond)}))
+}
+
+func TestConsumeClaimInBatches(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var mockFailureProducer = mock.NewSyncProducer(mockCtrl)
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var handlerError = errors.New("error from handler")
+
+	var consumerConf = createDefaultConsumerConfiguration()
+	consumerConf.Retry = &KafkaRetryRepresentation{MaxAttempts: new(2), InitialBackoff: new(time.Millisecond)}
+	consumerConf.Batch = &KafkaBatchRepresentation{MaxSize: new(2), MaxBytes: new(10), Linger: new(time.Hour)}
+	var aConsumer = newConsumer(cluster, consumerConf, logger)
+	aConsumer.failureProducer = &producer{initialized: true, enabled: true, id: "failure-producer", topic: new("failure-topic"), producer: mockFailureProducer}
+
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+
+	var newMessages = func(values ...string) chan *sarama.ConsumerMessage {
+		var messages = make(chan *sarama.ConsumerMessage, len(values))
+		for idx, value := range values {
+			messages <- &sarama.ConsumerMessage{Topic: "topic", Offset: int64(idx), Timestamp: time.Now(), Value: []byte(value)}
+		}
+		close(messages)
+		return messages
+	}
+	var contents = func(messages []KafkaMessage) []string {
+		var values []string
+		for _, msg := range messages {
+			values = append(values, string(msg.GetRawValue()))
+		}
+		return values
+	}
+
+	t.Run("Size and bytes thresholds", func(t *testing.T) {
+		var batches [][]string
+		aConsumer.SetBatchHandler(func(ctx context.Context, messages []KafkaMessage) error {
+			batches = append(batches, contents(messages))
+			return nil
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages("a", "b", "0123456789", "c"))
+		for _, offset := range []int64{1, 2, 3} {
+			mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Cond(func(msg *sarama.ConsumerMessage) bool { return msg.Offset == offset }), "")
+		}
+
+		assert.Nil(t, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, [][]string{{"a", "b"}, {"0123456789"}, {"c"}}, batches)
+	})
+	t.Run("Linger", func(t *testing.T) {
+		var lingerConsumer = newConsumer(cluster, consumerConf, logger)
+		lingerConsumer.batch.linger = time.Millisecond
+		var ctx, cancel = context.WithCancel(context.TODO())
+		var session = mock.NewConsumerGroupSession(mockCtrl)
+		session.EXPECT().Context().Return(ctx).AnyTimes()
+		lingerConsumer.SetBatchHandler(func(_ context.Context, messages []KafkaMessage) error {
+			assert.Equal(t, []string{"a"}, contents(messages))
+			return nil
+		})
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		messages <- &sarama.ConsumerMessage{Topic: "topic", Timestamp: time.Now(), Value: []byte("a")}
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		// The batch is handled once the linger time is elapsed, even though the claim is still open
+		session.EXPECT().MarkMessage(gomock.Any(), "").Do(func(*sarama.ConsumerMessage, string) { cancel() })
+
+		assert.Nil(t, lingerConsumer.ConsumeClaim(session, mockConsumerGroupClaim))
+	})
+	t.Run("Failed messages are retried then sent to the failure topic", func(t *testing.T) {
+		var batches [][]string
+		aConsumer.SetBatchHandler(func(ctx context.Context, messages []KafkaMessage) error {
+			batches = append(batches, contents(messages))
+			var failures = map[int]error{}
+			for idx, msg := range messages {
+				if string(msg.GetRawValue()) == "b" {
+					failures[idx] = handlerError
+				}
+			}
+			if len(failures) > 0 {
+				return &KafkaBatchError{Failures: failures}
+			}
+			return nil
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages("a", "b"))
+		mockFailureProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			assert.Equal(t, "failure-topic", msg.Topic)
+			assert.Equal(t, sarama.ByteEncoder("b"), msg.Value)
+			return 0, 0, nil
+		})
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Cond(func(msg *sarama.ConsumerMessage) bool { return msg.Offset == 1 }), "")
+
+		assert.Nil(t, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, [][]string{{"a", "b"}, {"b"}}, batches)
+	})
+	t.Run("Whole batch failure", func(t *testing.T) {
+		aConsumer.SetRetryableErrorPredicate(func(err error) bool { return false })
+		defer aConsumer.SetRetryableErrorPredicate(func(err error) bool { return true })
+		aConsumer.SetBatchHandler(func(ctx context.Context, messages []KafkaMessage) error {
+			return handlerError
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages("a", "b"))
+		mockFailureProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), nil).Times(2)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Failed messages are sent to the failure topic without retry policy", func(t *testing.T) {
+		var noRetryConf = createDefaultConsumerConfiguration()
+		noRetryConf.Batch = consumerConf.Batch
+		var noRetryConsumer = newConsumer(cluster, noRetryConf, logger)
+		noRetryConsumer.failureProducer = aConsumer.failureProducer
+		var calls = 0
+		noRetryConsumer.SetBatchHandler(func(ctx context.Context, messages []KafkaMessage) error {
+			calls++
+			return &KafkaBatchError{Failures: map[int]error{0: handlerError}}
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages("a", "b"))
+		mockFailureProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			assert.Equal(t, sarama.ByteEncoder("a"), msg.Value)
+			return 0, 0, nil
+		})
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Cond(func(msg *sarama.ConsumerMessage) bool { return msg.Offset == 1 }), "")
+
+		assert.Nil(t, noRetryConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("Abort consuming", func(t *testing.T) {
+		aConsumer.SetBatchHandler(func(ctx context.Context, messages []KafkaMessage) error {
+			messages[0].AbortConsuming()
+			return handlerError
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages("a", "b"))
+
+		assert.Equal(t, handlerError, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+}
@@ -2,9 +2,13 @@ package kafkauniverse
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/cloudtrust/kafka-client/misc"
@@ -17,15 +21,22 @@ type cluster struct {
 	saramaConfig   *sarama.Config
 	consumerGroups map[string]sarama.ConsumerGroup
 	logger         Logger
+	// newClient creates the clients used to read offsets and messages outside of the consumer groups
+	newClient func(brokers []string, config *sarama.Config) (sarama.Client, error)
 }
 
-func newCluster(ctx context.Context, conf KafkaClusterRepresentation, envKeyPrefix string, logger Logger) (*cluster, error) {
-	var secret = getEnvVariable(envKeyPrefix, *conf.ID, "_CLIENT_SECRET")
-	if secret != nil {
-		conf.Security.ClientSecret = secret
-	}
+// Default settings of the OAuth token cache
+const (
+	defaultTokenRefreshBefore = time.Minute
+	defaultTokenRefreshJitter = 10 * time.Second
+	defaultTokenFetchRetries  = 3
+	defaultTokenFetchBackoff  = 200 * time.Millisecond
+)
 
-	var saramaConfig, err = newSaramaConfig(ctx, conf, logger)
+func newCluster(ctx context.Context, conf KafkaClusterRepresentation, envKeyPrefix string, logger Logger, options universeOptions) (*cluster, error) {
+	overrideSecrets(envKeyPrefix, *conf.ID, conf.Security)
+
+	var saramaConfig, err = newSaramaConfig(ctx, conf, logger, options)
 	if err != nil {
 		return nil, err
 	}
@@ -42,9 +53,27 @@ func newCluster(ctx context.Context, conf KafkaClusterRepresentation, envKeyPref
 		saramaConfig:   saramaConfig,
 		consumerGroups: make(map[string]sarama.ConsumerGroup),
 		logger:         logger,
+		newClient:      sarama.NewClient,
 	}, nil
 }
 
+// overrideSecrets replaces the secrets of the security configuration with the values of the matching environment variables, if any
+func overrideSecrets(envKeyPrefix string, clusterID string, security *KafkaSecurityRepresentation) {
+	var secrets = []struct {
+		suffix string
+		value  **string
+	}{
+		{suffix: "_CLIENT_SECRET", value: &security.ClientSecret},
+		{suffix: "_USERNAME", value: &security.Username},
+		{suffix: "_PASSWORD", value: &security.Password},
+	}
+	for _, secret := range secrets {
+		if value := getEnvVariable(envKeyPrefix, clusterID, secret.suffix); value != nil {
+			*secret.value = value
+		}
+	}
+}
+
 func getEnvVariable(prefix string, clusterID string, suffix string) *string {
 	var key = prefix + getEnvVariableName(clusterID) + suffix
 	var value = os.Getenv(key)
@@ -58,7 +87,7 @@ func getEnvVariableName(clusterID string) string {
 	return strings.ReplaceAll(strings.ToUpper(clusterID), "-", "_")
 }
 
-func newSaramaConfig(ctx context.Context, conf KafkaClusterRepresentation, logger Logger) (*sarama.Config, error) {
+func newSaramaConfig(ctx context.Context, conf KafkaClusterRepresentation, logger Logger, options universeOptions) (*sarama.Config, error) {
 	version, err := sarama.ParseKafkaVersion(*conf.Version)
 	if err != nil {
 		logger.Warn(ctx, "msg", "Failed to parse Kafka version", "err", err, "version", *conf.Version)
@@ -69,17 +98,188 @@ func newSaramaConfig(ctx context.Context, conf KafkaClusterRepresentation, logge
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
 	config.Consumer.Return.Errors = true
 	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = newExplicitPartitioner(config.Producer.Partitioner)
+	applyClusterTuning(config, conf.Sarama)
+
+	var tokenMetrics = &clusterTokenMetrics{ctx: ctx, clusterID: *conf.ID, metrics: options.tokenMetrics, logger: logger}
+	if err = configureAuthentication(config, conf.Security, options.secretResolvers, tokenMetrics); err != nil {
+		logger.Warn(ctx, "msg", "Failed to configure authentication", "err", err, "cluster", *conf.ID)
+		return nil, err
+	}
+
+	config.Net.TLS.Enable = conf.IsTLSEnabled()
+	if conf.TLS != nil {
+		if config.Net.TLS.Config, err = newTLSConfig(*conf.TLS, options.secretResolvers); err != nil {
+			logger.Warn(ctx, "msg", "Failed to load TLS configuration", "err", err, "cluster", *conf.ID)
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
 
-	// Enables Oauth2 authentification
+func configureAuthentication(config *sarama.Config, security *KafkaSecurityRepresentation, secrets secretResolvers, tokenMetrics misc.TokenMetrics) error {
+	var mechanism = security.GetMechanism()
+	if mechanism == mechanismNone {
+		config.Net.SASL.Enable = false
+		return nil
+	}
 	config.Net.SASL.Enable = true
-	config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
-	config.Net.SASL.TokenProvider = misc.NewTokenProvider(*conf.Security.ClientID, *conf.Security.ClientSecret, *conf.Security.TokenURL)
+	switch mechanism {
+	case mechanismOAuthBearer:
+		// Enables Oauth2 authentification
+		config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+		var tokenProviderConfig, err = newTokenProviderConfig(security, secrets)
+		if err != nil {
+			return err
+		}
+		tokenProviderConfig.Metrics = tokenMetrics
+		if config.Net.SASL.TokenProvider, err = misc.NewTokenProviderWithConfig(tokenProviderConfig); err != nil {
+			return err
+		}
+		return nil
+	case mechanismPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case mechanismScramSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+	case mechanismScramSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+	}
 
-	config.Net.TLS.Enable = *conf.TLSEnabled
+	config.Net.SASL.User = *security.Username
+	// SCRAM clients resolve the password again for each authentication. Sarama reads the PLAIN password from its configuration, which
+	// is copied by each client: it is only resolved at startup
+	var password, err = secrets.provider(*security.Password)
+	if err != nil {
+		return err
+	}
+	if config.Net.SASL.Password, err = password(); err != nil {
+		return err
+	}
+	switch mechanism {
+	case mechanismScramSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = misc.NewSCRAMSHA256ClientGenerator(password)
+	case mechanismScramSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = misc.NewSCRAMSHA512ClientGenerator(password)
+	}
+	return nil
+}
 
+func newTokenProviderConfig(security *KafkaSecurityRepresentation, secrets secretResolvers) (misc.TokenProviderConfig, error) {
+	var config = misc.TokenProviderConfig{
+		Scopes:        security.Scopes,
+		Extensions:    security.Extensions,
+		RefreshBefore: durationValue(security.TokenRefreshBefore, defaultTokenRefreshBefore),
+		RefreshJitter: durationValue(security.TokenRefreshJitter, defaultTokenRefreshJitter),
+		FetchRetries:  defaultTokenFetchRetries,
+		FetchBackoff:  durationValue(security.TokenFetchBackoff, defaultTokenFetchBackoff),
+	}
+	if security.TokenFetchRetries != nil {
+		config.FetchRetries = *security.TokenFetchRetries
+	}
+	switch security.GetTokenSource() {
+	case tokenSourceFile:
+		config.TokenFile = *security.TokenFile
+		return config, nil
+	case tokenSourcePrivateKeyJWT:
+		config.PrivateKeyFile = *security.PrivateKeyFile
+		config.KeyID = stringValue(security.KeyID)
+	default:
+		var err error
+		if config.ClientSecretProvider, err = secrets.provider(*security.ClientSecret); err != nil {
+			return config, err
+		}
+	}
+	config.ClientID = *security.ClientID
+	config.TokenURL = *security.TokenURL
+	if len(security.EndpointParams) > 0 {
+		config.EndpointParams = url.Values{}
+		for name, value := range security.EndpointParams {
+			config.EndpointParams.Set(name, value)
+		}
+	}
+	if security.AuthStyle != nil {
+		config.AuthStyle = authStyles[*security.AuthStyle]
+	}
+	if security.HTTPTimeout != nil {
+		config.HTTPTimeout = *security.HTTPTimeout
+	}
+	if security.TokenTLS != nil {
+		var options, err = newTLSOptions(*security.TokenTLS, secrets)
+		if err != nil {
+			return config, err
+		}
+		config.TLS = &options
+	}
 	return config, nil
 }
 
+func newTLSConfig(tlsRep KafkaTLSRepresentation, secrets secretResolvers) (*tls.Config, error) {
+	var options, err = newTLSOptions(tlsRep, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return misc.NewTLSConfig(options)
+}
+
+func newTLSOptions(tlsRep KafkaTLSRepresentation, secrets secretResolvers) (misc.TLSOptions, error) {
+	var options = misc.TLSOptions{
+		CAFile:             stringValue(tlsRep.CAFile),
+		CAPEM:              stringValue(tlsRep.CAPEM),
+		CertFile:           stringValue(tlsRep.CertFile),
+		KeyFile:            stringValue(tlsRep.KeyFile),
+		ServerName:         stringValue(tlsRep.ServerName),
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: tlsRep.InsecureSkipVerify != nil && *tlsRep.InsecureSkipVerify,
+	}
+	if tlsRep.MinVersion != nil {
+		options.MinVersion = tlsVersions[*tlsRep.MinVersion]
+	}
+	if tlsRep.KeyPassphrase != nil {
+		var err error
+		if options.KeyPassphrase, err = secrets.provider(*tlsRep.KeyPassphrase); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+func durationValue(value *time.Duration, defaultValue time.Duration) time.Duration {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
+
+// clusterTokenMetrics logs the measures about the OAuth tokens of a cluster and forwards them to the configured TokenMetrics, if any
+type clusterTokenMetrics struct {
+	ctx       context.Context
+	clusterID string
+	metrics   TokenMetrics
+	logger    Logger
+}
+
+func (m *clusterTokenMetrics) TokenFetched(latency time.Duration, expiry time.Time) {
+	m.logger.Debug(m.ctx, "msg", "OAuth token fetched", "cluster", m.clusterID, "latency", latency, "expiry", expiry)
+	if m.metrics != nil {
+		m.metrics.TokenFetched(m.clusterID, latency, expiry)
+	}
+}
+
+func (m *clusterTokenMetrics) TokenFetchFailed(latency time.Duration, err error) {
+	m.logger.Warn(m.ctx, "msg", "Failed to fetch OAuth token", "err", err, "cluster", m.clusterID, "latency", latency)
+	if m.metrics != nil {
+		m.metrics.TokenFetchFailed(m.clusterID, latency, err)
+	}
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
 func (c *cluster) Close() error {
 	var anError error
 	for name, consumerGroup := range c.consumerGroups {
@@ -88,6 +288,12 @@ func (c *cluster) Close() error {
 			anError = err
 		}
 	}
+	// Stops refreshing the OAuth token
+	if c.saramaConfig != nil {
+		if closer, ok := c.saramaConfig.Net.SASL.TokenProvider.(io.Closer); ok {
+			closer.Close()
+		}
+	}
 	return anError
 }
 
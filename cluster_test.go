@@ -1,12 +1,228 @@
 package kafkauniverse
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/oauth2"
 )
 
 func TestGetEnvVariable(t *testing.T) {
 	assert.Equal(t, "TO_VARIABLE2", getEnvVariableName("to-variable2"))
 	assert.NotNil(t, getEnvVariable("PA", "t", "H")) // will match env variable PATH
 }
+
+func TestOverrideSecrets(t *testing.T) {
+	t.Setenv("ENV_MY_CLUSTER_CLIENT_SECRET", "env-client-secret")
+	t.Setenv("ENV_MY_CLUSTER_PASSWORD", "env-password")
+
+	var security = KafkaSecurityRepresentation{
+		ClientSecret: new("client-secret"),
+		Username:     new("username"),
+		Password:     new("password"),
+	}
+	overrideSecrets("ENV_", "my-cluster", &security)
+	assert.Equal(t, "env-client-secret", *security.ClientSecret)
+	assert.Equal(t, "username", *security.Username)
+	assert.Equal(t, "env-password", *security.Password)
+}
+
+func TestConfigureAuthentication(t *testing.T) {
+	var userPassword = KafkaSecurityRepresentation{Username: new("user"), Password: new("password")}
+
+	t.Run("OAuth bearer", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		assert.Nil(t, configureAuthentication(config, &KafkaSecurityRepresentation{ClientID: new("id"), ClientSecret: new("secret"), TokenURL: new("https://token")}, newSecretResolvers(), nil))
+		assert.True(t, config.Net.SASL.Enable)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeOAuth), config.Net.SASL.Mechanism)
+		assert.NotNil(t, config.Net.SASL.TokenProvider)
+	})
+	t.Run("Plain", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		userPassword.Mechanism = new("plain")
+		assert.Nil(t, configureAuthentication(config, &userPassword, newSecretResolvers(), nil))
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), config.Net.SASL.Mechanism)
+		assert.Equal(t, "user", config.Net.SASL.User)
+		assert.Equal(t, "password", config.Net.SASL.Password)
+	})
+	t.Run("Plain with password reference", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		var security = KafkaSecurityRepresentation{Mechanism: new("plain"), Username: new("user"), Password: new("env:KAFKA_PASSWORD")}
+		t.Setenv("KAFKA_PASSWORD", "env-password")
+		assert.Nil(t, configureAuthentication(config, &security, newSecretResolvers(), nil))
+		assert.Equal(t, "env-password", config.Net.SASL.Password)
+
+		// Invalid references are detected at startup
+		security.Password = new("env:KAFKA_MISSING_PASSWORD")
+		assert.NotNil(t, configureAuthentication(config, &security, newSecretResolvers(), nil))
+
+		// Not a registered scheme
+		security.Password = new("pass:word")
+		assert.Nil(t, configureAuthentication(config, &security, newSecretResolvers(), nil))
+		assert.Equal(t, "pass:word", config.Net.SASL.Password)
+	})
+	t.Run("SCRAM", func(t *testing.T) {
+		for mechanism, expected := range map[string]sarama.SASLMechanism{
+			"scram-sha-256": sarama.SASLTypeSCRAMSHA256,
+			"scram-sha-512": sarama.SASLTypeSCRAMSHA512,
+		} {
+			var config = sarama.NewConfig()
+			userPassword.Mechanism = &mechanism
+			assert.Nil(t, configureAuthentication(config, &userPassword, newSecretResolvers(), nil))
+			assert.Equal(t, expected, config.Net.SASL.Mechanism)
+			assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc())
+			assert.Nil(t, config.Validate())
+		}
+	})
+	t.Run("OAuth bearer with invalid token TLS", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		var err = configureAuthentication(config, &KafkaSecurityRepresentation{ClientID: new("id"), ClientSecret: new("secret"),
+			TokenURL: new("https://token"), TokenTLS: &KafkaTLSRepresentation{CAFile: new(filepath.Join(t.TempDir(), "missing.pem"))}}, newSecretResolvers(), nil)
+		assert.NotNil(t, err)
+	})
+	t.Run("Password references", func(t *testing.T) {
+		var passwordFile = filepath.Join(t.TempDir(), "password")
+		assert.Nil(t, os.WriteFile(passwordFile, []byte("file-password"), 0600))
+		var config = sarama.NewConfig()
+		var security = KafkaSecurityRepresentation{Mechanism: new("scram-sha-256"), Username: new("user"), Password: new("file:" + passwordFile)}
+		assert.Nil(t, configureAuthentication(config, &security, newSecretResolvers(), nil))
+		assert.Equal(t, "file-password", config.Net.SASL.Password)
+
+		security.Password = new("env:KAFKA_TEST_MISSING_PASSWORD")
+		assert.NotNil(t, configureAuthentication(sarama.NewConfig(), &security, newSecretResolvers(), nil))
+		assert.NotNil(t, configureAuthentication(sarama.NewConfig(), &KafkaSecurityRepresentation{ClientID: new("id"),
+			ClientSecret: new("env:KAFKA_TEST_MISSING_SECRET"), TokenURL: new("https://token")}, newSecretResolvers(), nil))
+	})
+	t.Run("None", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		assert.Nil(t, configureAuthentication(config, &KafkaSecurityRepresentation{Mechanism: new("none")}, newSecretResolvers(), nil))
+		assert.False(t, config.Net.SASL.Enable)
+	})
+}
+
+func TestNewTokenProviderConfig(t *testing.T) {
+	var config, err = newTokenProviderConfig(&KafkaSecurityRepresentation{
+		ClientID:       new("id"),
+		ClientSecret:   new("secret"),
+		TokenURL:       new("https://token"),
+		Scopes:         []string{"kafka", "profile"},
+		EndpointParams: map[string]string{"audience": "kafka-cluster"},
+		AuthStyle:      new("header"),
+		HTTPTimeout:    new(5 * time.Second),
+		TokenTLS:       &KafkaTLSRepresentation{ServerName: new("idp")},
+		Extensions:     map[string]string{"logicalCluster": "lkc-1"},
+	}, newSecretResolvers())
+	assert.Nil(t, err)
+	assert.Equal(t, "id", config.ClientID)
+	clientSecret, err := config.ClientSecretProvider()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", clientSecret)
+	assert.Equal(t, []string{"kafka", "profile"}, config.Scopes)
+	assert.Equal(t, "kafka-cluster", config.EndpointParams.Get("audience"))
+	assert.Equal(t, oauth2.AuthStyleInHeader, config.AuthStyle)
+	assert.Equal(t, 5*time.Second, config.HTTPTimeout)
+	assert.Equal(t, "idp", config.TLS.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.TLS.MinVersion)
+	assert.Equal(t, map[string]string{"logicalCluster": "lkc-1"}, config.Extensions)
+	assert.Equal(t, defaultTokenRefreshBefore, config.RefreshBefore)
+	assert.Equal(t, defaultTokenRefreshJitter, config.RefreshJitter)
+	assert.Equal(t, defaultTokenFetchRetries, config.FetchRetries)
+	assert.Equal(t, defaultTokenFetchBackoff, config.FetchBackoff)
+
+	t.Run("Token cache settings", func(t *testing.T) {
+		var config, err = newTokenProviderConfig(&KafkaSecurityRepresentation{ClientID: new("id"), ClientSecret: new("secret"),
+			TokenURL: new("https://token"), TokenRefreshBefore: new(2 * time.Minute), TokenRefreshJitter: new(time.Duration(0)),
+			TokenFetchRetries: new(0), TokenFetchBackoff: new(time.Second)}, newSecretResolvers())
+		assert.Nil(t, err)
+		assert.Equal(t, 2*time.Minute, config.RefreshBefore)
+		assert.Equal(t, time.Duration(0), config.RefreshJitter)
+		assert.Equal(t, 0, config.FetchRetries)
+		assert.Equal(t, time.Second, config.FetchBackoff)
+	})
+}
+
+type recordedTokenMetrics struct {
+	fetched  []string
+	failures []error
+}
+
+func (m *recordedTokenMetrics) TokenFetched(clusterID string, _ time.Duration, _ time.Time) {
+	m.fetched = append(m.fetched, clusterID)
+}
+
+func (m *recordedTokenMetrics) TokenFetchFailed(_ string, _ time.Duration, err error) {
+	m.failures = append(m.failures, err)
+}
+
+func TestClusterTokenMetrics(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var anError = errors.New("identity provider unavailable")
+	var ctx = context.TODO()
+
+	t.Run("Without metrics", func(t *testing.T) {
+		var tokenMetrics = &clusterTokenMetrics{ctx: ctx, clusterID: "cluster1", logger: logger}
+		logger.EXPECT().Debug(ctx, gomock.Any())
+		tokenMetrics.TokenFetched(time.Millisecond, time.Now())
+		logger.EXPECT().Warn(ctx, gomock.Any())
+		tokenMetrics.TokenFetchFailed(time.Millisecond, anError)
+	})
+	t.Run("With metrics", func(t *testing.T) {
+		var metrics = &recordedTokenMetrics{}
+		var tokenMetrics = &clusterTokenMetrics{ctx: ctx, clusterID: "cluster1", metrics: metrics, logger: logger}
+		logger.EXPECT().Debug(ctx, gomock.Any())
+		tokenMetrics.TokenFetched(time.Millisecond, time.Now())
+		logger.EXPECT().Warn(ctx, gomock.Any())
+		tokenMetrics.TokenFetchFailed(time.Millisecond, anError)
+		assert.Equal(t, []string{"cluster1"}, metrics.fetched)
+		assert.Equal(t, []error{anError}, metrics.failures)
+	})
+}
+
+func TestNewTokenProviderConfigSources(t *testing.T) {
+	t.Run("Private key JWT", func(t *testing.T) {
+		var config, err = newTokenProviderConfig(&KafkaSecurityRepresentation{TokenSource: new("private-key-jwt"), ClientID: new("id"),
+			TokenURL: new("https://token"), PrivateKeyFile: new("client.key"), KeyID: new("key-1")}, newSecretResolvers())
+		assert.Nil(t, err)
+		assert.Equal(t, "id", config.ClientID)
+		assert.Nil(t, config.ClientSecretProvider)
+		assert.Equal(t, "client.key", config.PrivateKeyFile)
+		assert.Equal(t, "key-1", config.KeyID)
+	})
+	t.Run("File", func(t *testing.T) {
+		var config, err = newTokenProviderConfig(&KafkaSecurityRepresentation{TokenSource: new("file"), TokenFile: new("/var/run/token")}, newSecretResolvers())
+		assert.Nil(t, err)
+		assert.Equal(t, "/var/run/token", config.TokenFile)
+		assert.Equal(t, "", config.TokenURL)
+	})
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	t.Run("Default min version", func(t *testing.T) {
+		var config, err = newTLSConfig(KafkaTLSRepresentation{}, newSecretResolvers())
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	})
+	t.Run("Options", func(t *testing.T) {
+		var config, err = newTLSConfig(KafkaTLSRepresentation{ServerName: new("broker"), MinVersion: new("1.3"), InsecureSkipVerify: new(true)}, newSecretResolvers())
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+		assert.Equal(t, "broker", config.ServerName)
+		assert.True(t, config.InsecureSkipVerify)
+	})
+	t.Run("Invalid CA file", func(t *testing.T) {
+		var _, err = newTLSConfig(KafkaTLSRepresentation{CAFile: new(filepath.Join(t.TempDir(), "missing.pem"))}, newSecretResolvers())
+		assert.NotNil(t, err)
+	})
+}
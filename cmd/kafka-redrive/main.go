@@ -0,0 +1,205 @@
+// Command kafka-redrive republishes the messages parked in a failure topic.
+//
+// The failure topic is the topic of a consumer and the messages are republished through a producer, both declared in the
+// KafkaUniverse YAML configuration. By default, each message is sent back to the topic found in its x-original-topic header.
+//
+//	kafka-redrive -config app.yml -config-key my-kafka-key -consumer failure-consumer -producer redrive-producer \
+//	    -from 2024-01-01T00:00:00Z -header x-original-topic=my.topic -dry-run
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	kafkauniverse "github.com/cloudtrust/kafka-client"
+	"github.com/go-viper/mapstructure/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type logger struct {
+	verbose bool
+}
+
+func (l *logger) log(level string, keyvals ...any) {
+	log.Println(append([]any{"level", level}, keyvals...)...)
+}
+
+func (l *logger) Debug(ctx context.Context, keyvals ...any) {
+	if l.verbose {
+		l.log("debug", keyvals...)
+	}
+}
+
+func (l *logger) Info(ctx context.Context, keyvals ...any) {
+	if l.verbose {
+		l.log("info", keyvals...)
+	}
+}
+
+func (l *logger) Warn(ctx context.Context, keyvals ...any) {
+	l.log("warn", keyvals...)
+}
+
+func (l *logger) Error(ctx context.Context, keyvals ...any) {
+	l.log("error", keyvals...)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kafka-redrive:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var flags = flag.NewFlagSet("kafka-redrive", flag.ContinueOnError)
+	var (
+		configFile   = flags.String("config", "", "YAML file containing the KafkaUniverse configuration")
+		configKey    = flags.String("config-key", "", "key of the KafkaUniverse configuration in the YAML file. Empty if the file only contains the clusters list")
+		envPrefix    = flags.String("env-prefix", "", "prefix of the environment variables overriding the configuration")
+		consumerID   = flags.String("consumer", "", "ID of the consumer configured to read the failure topic")
+		producerID   = flags.String("producer", "", "ID of the producer used to republish the messages")
+		targetTopic  = flags.String("target-topic", "", "topic where messages are republished. Default: x-original-topic header, then producer topic")
+		from         = flags.String("from", "", "only redrive messages produced after this RFC3339 time")
+		to           = flags.String("to", "", "only redrive messages produced before this RFC3339 time")
+		dryRun       = flags.Bool("dry-run", false, "only report the messages which would be redriven")
+		idleTimeout  = flags.Duration("idle-timeout", 0, "time without message after which a partition is no longer read, although its newest offset is not reached. Default 5s")
+		verbose      = flags.Bool("verbose", false, "log informational messages")
+		keys         stringList
+		headerValues stringList
+	)
+	flags.Var(&keys, "key", "only redrive messages with this key. Can be repeated")
+	flags.Var(&headerValues, "header", "only redrive messages with this header value, written name=value. Can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configFile == "" || *consumerID == "" || *producerID == "" {
+		flags.Usage()
+		return errors.New("config, consumer and producer are mandatory")
+	}
+
+	var options = kafkauniverse.RedriveOptions{
+		ConsumerID:  *consumerID,
+		ProducerID:  *producerID,
+		TargetTopic: *targetTopic,
+		Keys:        keys,
+		Headers:     map[string]string{},
+		DryRun:      *dryRun,
+		IdleTimeout: *idleTimeout,
+	}
+	var err error
+	if options.From, err = parseTime(*from); err != nil {
+		return err
+	}
+	if options.To, err = parseTime(*to); err != nil {
+		return err
+	}
+	for _, headerValue := range headerValues {
+		var name, value, ok = strings.Cut(headerValue, "=")
+		if !ok {
+			return fmt.Errorf("invalid header filter %s: expected name=value", headerValue)
+		}
+		options.Headers[name] = value
+	}
+
+	var ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var universe *kafkauniverse.KafkaUniverse
+	universe, err = kafkauniverse.NewKafkaUniverse(ctx, &logger{verbose: *verbose}, *envPrefix, func(target any) error {
+		return loadConfiguration(*configFile, *configKey, target)
+	})
+	if err != nil {
+		return err
+	}
+	defer universe.Close()
+	if !options.DryRun {
+		if err = universe.InitializeProducers(options.ProducerID); err != nil {
+			return err
+		}
+	}
+
+	report, err := universe.Redrive(ctx, options)
+	if report != nil {
+		printReport(report, options.DryRun)
+	}
+	if err == nil && len(report.Incomplete) > 0 {
+		err = fmt.Errorf("%d partition(s) not fully read: redrive them again from their next offset, with a longer idle-timeout if needed", len(report.Incomplete))
+	}
+	return err
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// loadConfiguration decodes the KafkaUniverse configuration the same way applications do with viper: using the mapstructure tags
+func loadConfiguration(configFile string, configKey string, target any) error {
+	var content, err = os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var document map[string]any
+	var input any
+	if configKey == "" {
+		err = yaml.Unmarshal(content, &input)
+	} else if err = yaml.Unmarshal(content, &document); err == nil {
+		var ok bool
+		if input, ok = document[configKey]; !ok {
+			return fmt.Errorf("key %s not found in %s", configKey, configFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           target,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+func printReport(report *kafkauniverse.RedriveReport, dryRun bool) {
+	var action, count = "redriven", report.Redriven
+	if dryRun {
+		// Nothing is redriven in dry run: all the matched messages would be
+		action, count = "to redrive", report.Matched
+	}
+	for _, entry := range report.Entries {
+		var status = "ok"
+		if entry.Err != nil {
+			status = entry.Err.Error()
+		}
+		fmt.Printf("partition=%d offset=%d key=%q target=%s status=%s\n", entry.Partition, entry.Offset, entry.Key, entry.TargetTopic, status)
+	}
+	for _, incomplete := range report.Incomplete {
+		fmt.Printf("partition=%d incomplete nextOffset=%d endOffset=%d\n", incomplete.Partition, incomplete.NextOffset, incomplete.EndOffset)
+	}
+	fmt.Printf("scanned=%d matched=%d %s=%d failed=%d incomplete=%d\n", report.Scanned, report.Matched, action, count, report.Failed,
+		len(report.Incomplete))
+}
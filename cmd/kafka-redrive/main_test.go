@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kafkauniverse "github.com/cloudtrust/kafka-client"
+	"github.com/stretchr/testify/assert"
+)
+
+const configuration = `
+my-kafka-key:
+- id: cluster1
+  enabled: false
+  version: "3.1.0"
+  tls-enabled: false
+  brokers:
+  - "kafka11.domain.ch:9093"
+  security:
+    client-id: your-client-id
+    client-secret: your-client-secret
+    token-url: https://path.to/your/token
+  producers:
+  - id: redrive-producer
+    topic: my.topic
+  consumers:
+  - id: failure-consumer
+    topic: my.topic.failure
+    consumer-group-name: redrive
+    consumption-delay: 5m
+`
+
+func writeConfiguration(t *testing.T) string {
+	var configFile = filepath.Join(t.TempDir(), "config.yml")
+	assert.Nil(t, os.WriteFile(configFile, []byte(configuration), 0600))
+	return configFile
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	var configFile = writeConfiguration(t)
+
+	t.Run("Missing file", func(t *testing.T) {
+		var clusters []kafkauniverse.KafkaClusterRepresentation
+		assert.NotNil(t, loadConfiguration(configFile+".missing", "my-kafka-key", &clusters))
+	})
+	t.Run("Missing key", func(t *testing.T) {
+		var clusters []kafkauniverse.KafkaClusterRepresentation
+		assert.NotNil(t, loadConfiguration(configFile, "unknown-key", &clusters))
+	})
+	t.Run("Success", func(t *testing.T) {
+		var clusters []kafkauniverse.KafkaClusterRepresentation
+		assert.Nil(t, loadConfiguration(configFile, "my-kafka-key", &clusters))
+		assert.Len(t, clusters, 1)
+		assert.Nil(t, clusters[0].Validate())
+		assert.Equal(t, "your-client-id", *clusters[0].Security.ClientID)
+		assert.Equal(t, 5*time.Minute, *clusters[0].Consumers[0].ConsumptionDelay)
+	})
+}
+
+func TestRun(t *testing.T) {
+	var configFile = writeConfiguration(t)
+
+	t.Run("Missing mandatory flags", func(t *testing.T) {
+		assert.NotNil(t, run([]string{"-config", configFile}))
+	})
+	t.Run("Invalid time", func(t *testing.T) {
+		assert.NotNil(t, run([]string{"-config", configFile, "-consumer", "failure-consumer", "-producer", "redrive-producer", "-from", "yesterday"}))
+	})
+	t.Run("Invalid idle timeout", func(t *testing.T) {
+		assert.NotNil(t, run([]string{"-config", configFile, "-consumer", "failure-consumer", "-producer", "redrive-producer", "-idle-timeout", "soon"}))
+	})
+	t.Run("Invalid header filter", func(t *testing.T) {
+		assert.NotNil(t, run([]string{"-config", configFile, "-consumer", "failure-consumer", "-producer", "redrive-producer", "-header", "name"}))
+	})
+	t.Run("Disabled cluster", func(t *testing.T) {
+		var err = run([]string{"-config", configFile, "-config-key", "my-kafka-key", "-consumer", "failure-consumer", "-producer", "redrive-producer",
+			"-to", "2024-01-01T00:00:00Z", "-key", "a-key", "-header", "name=value"})
+		assert.ErrorContains(t, err, "disabled")
+	})
+}
@@ -1,23 +1,84 @@
 package kafkauniverse
 
 import (
+	"crypto/tls"
 	"errors"
 	"slices"
 	"time"
+
+	"github.com/IBM/sarama"
+	"golang.org/x/oauth2"
 )
 
 const (
 	offsetNewestParam = "newest"
 	offsetOldestParam = "oldest"
+
+	failurePolicyRestart = "restart"
+	failurePolicyStop    = "stop"
+	failurePolicyExit    = "exit"
+
+	mechanismOAuthBearer = "oauthbearer"
+	mechanismPlain       = "plain"
+	mechanismScramSHA256 = "scram-sha-256"
+	mechanismScramSHA512 = "scram-sha-512"
+	mechanismNone        = "none"
+
+	tokenSourceClientSecret  = "client-secret"
+	tokenSourcePrivateKeyJWT = "private-key-jwt"
+	tokenSourceFile          = "file"
+
+	requiredAcksNone   = "none"
+	requiredAcksLeader = "leader"
+	requiredAcksAll    = "all"
+
+	isolationLevelReadUncommitted = "read_uncommitted"
+	isolationLevelReadCommitted   = "read_committed"
 )
 
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var requiredAcks = map[string]sarama.RequiredAcks{
+	requiredAcksNone:   sarama.NoResponse,
+	requiredAcksLeader: sarama.WaitForLocal,
+	requiredAcksAll:    sarama.WaitForAll,
+}
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+// rebalanceStrategies gives the constructors of the supported rebalance strategies
+var rebalanceStrategies = map[string]func() sarama.BalanceStrategy{
+	"range":       sarama.NewBalanceStrategyRange,
+	"round-robin": sarama.NewBalanceStrategyRoundRobin,
+	"sticky":      sarama.NewBalanceStrategySticky,
+}
+
+var authStyles = map[string]oauth2.AuthStyle{
+	"auto":   oauth2.AuthStyleAutoDetect,
+	"header": oauth2.AuthStyleInHeader,
+	"params": oauth2.AuthStyleInParams,
+}
+
 // KafkaClusterRepresentation struct
 type KafkaClusterRepresentation struct {
 	ID               *string                       `mapstructure:"id"`
 	Enabled          *bool                         `mapstructure:"enabled"`
 	Version          *string                       `mapstructure:"version"`
 	TLSEnabled       *bool                         `mapstructure:"tls-enabled"`
+	TLS              *KafkaTLSRepresentation       `mapstructure:"tls"`
 	SaramaLogEnabled *bool                         `mapstructure:"sarama-log-enabled"`
+	Sarama           *KafkaSaramaRepresentation    `mapstructure:"sarama"`
 	Brokers          []string                      `mapstructure:"brokers"`
 	Security         *KafkaSecurityRepresentation  `mapstructure:"security"`
 	Producers        []KafkaProducerRepresentation `mapstructure:"producers"`
@@ -26,27 +87,135 @@ type KafkaClusterRepresentation struct {
 
 // KafkaSecurityRepresentation struct
 type KafkaSecurityRepresentation struct {
-	ClientID     *string `mapstructure:"client-id"`
-	ClientSecret *string `mapstructure:"client-secret"`
-	TokenURL     *string `mapstructure:"token-url"`
+	Mechanism          *string                 `mapstructure:"mechanism"`
+	ClientID           *string                 `mapstructure:"client-id"`
+	ClientSecret       *string                 `mapstructure:"client-secret"`
+	TokenURL           *string                 `mapstructure:"token-url"`
+	TokenSource        *string                 `mapstructure:"token-source"`
+	PrivateKeyFile     *string                 `mapstructure:"private-key-file"`
+	KeyID              *string                 `mapstructure:"key-id"`
+	TokenFile          *string                 `mapstructure:"token-file"`
+	Scopes             []string                `mapstructure:"scopes"`
+	EndpointParams     map[string]string       `mapstructure:"endpoint-params"`
+	AuthStyle          *string                 `mapstructure:"auth-style"`
+	HTTPTimeout        *time.Duration          `mapstructure:"http-timeout"`
+	TokenTLS           *KafkaTLSRepresentation `mapstructure:"token-tls"`
+	Extensions         map[string]string       `mapstructure:"extensions"`
+	TokenRefreshBefore *time.Duration          `mapstructure:"token-refresh-before"`
+	TokenRefreshJitter *time.Duration          `mapstructure:"token-refresh-jitter"`
+	TokenFetchRetries  *int                    `mapstructure:"token-fetch-retries"`
+	TokenFetchBackoff  *time.Duration          `mapstructure:"token-fetch-backoff"`
+	Username           *string                 `mapstructure:"username"`
+	Password           *string                 `mapstructure:"password"`
+}
+
+// KafkaTLSRepresentation struct
+type KafkaTLSRepresentation struct {
+	CAFile             *string `mapstructure:"ca-file"`
+	CAPEM              *string `mapstructure:"ca-pem"`
+	CertFile           *string `mapstructure:"cert-file"`
+	KeyFile            *string `mapstructure:"key-file"`
+	KeyPassphrase      *string `mapstructure:"key-passphrase"`
+	ServerName         *string `mapstructure:"server-name"`
+	MinVersion         *string `mapstructure:"min-version"`
+	InsecureSkipVerify *bool   `mapstructure:"insecure-skip-verify"`
 }
 
 // KafkaProducerRepresentation struct
 type KafkaProducerRepresentation struct {
-	ID      *string `mapstructure:"id"`
-	Enabled *bool   `mapstructure:"enabled"`
-	Topic   *string `mapstructure:"topic"`
+	ID              *string                            `mapstructure:"id"`
+	Enabled         *bool                              `mapstructure:"enabled"`
+	Topic           *string                            `mapstructure:"topic"`
+	Async           *bool                              `mapstructure:"async"`
+	MaxInFlight     *int                               `mapstructure:"max-in-flight"`
+	TransactionalID *string                            `mapstructure:"transactional-id"`
+	Sarama          *KafkaSaramaProducerRepresentation `mapstructure:"sarama"`
 }
 
 // KafkaConsumerRepresentation struct
 type KafkaConsumerRepresentation struct {
-	ID                *string        `mapstructure:"id"`
-	Enabled           *bool          `mapstructure:"enabled"`
-	Topic             *string        `mapstructure:"topic"`
-	ConsumerGroupName *string        `mapstructure:"consumer-group-name"`
-	FailureProducer   *string        `mapstructure:"failure-producer"`
-	ConsumptionDelay  *time.Duration `mapstructure:"consumption-delay"`
-	InitialOffset     *string        `mapstructure:"initial-offset"`
+	ID                    *string                            `mapstructure:"id"`
+	Enabled               *bool                              `mapstructure:"enabled"`
+	Topic                 *string                            `mapstructure:"topic"`
+	ConsumerGroupName     *string                            `mapstructure:"consumer-group-name"`
+	FailureProducer       *string                            `mapstructure:"failure-producer"`
+	ConsumptionDelay      *time.Duration                     `mapstructure:"consumption-delay"`
+	InitialOffset         *string                            `mapstructure:"initial-offset"`
+	FailurePolicy         *string                            `mapstructure:"failure-policy"`
+	RestartBackoff        *time.Duration                     `mapstructure:"restart-backoff"`
+	RestartMaxBackoff     *time.Duration                     `mapstructure:"restart-max-backoff"`
+	Retry                 *KafkaRetryRepresentation          `mapstructure:"retry"`
+	RetryStages           []KafkaRetryStageRepresentation    `mapstructure:"retry-stages"`
+	TransactionalProducer *string                            `mapstructure:"transactional-producer"`
+	IsolationLevel        *string                            `mapstructure:"isolation-level"`
+	Sarama                *KafkaSaramaConsumerRepresentation `mapstructure:"sarama"`
+	RebalanceStrategies   []string                           `mapstructure:"rebalance-strategies"`
+	GroupInstanceID       *string                            `mapstructure:"group-instance-id"`
+	RackID                *string                            `mapstructure:"rack-id"`
+	Workers               *int                               `mapstructure:"workers"`
+	MaxInFlight           *int                               `mapstructure:"max-in-flight"`
+	Batch                 *KafkaBatchRepresentation          `mapstructure:"batch"`
+	ResetOffsets          *KafkaOffsetResetRepresentation    `mapstructure:"reset-offsets"`
+}
+
+// KafkaSaramaRepresentation struct: tuning of the sarama client of a cluster. Producer and consumer settings apply to all the producers
+// and consumers of the cluster and can be overridden by each of them
+type KafkaSaramaRepresentation struct {
+	ClientID                 *string                            `mapstructure:"client-id"`
+	DialTimeout              *time.Duration                     `mapstructure:"dial-timeout"`
+	ReadTimeout              *time.Duration                     `mapstructure:"read-timeout"`
+	WriteTimeout             *time.Duration                     `mapstructure:"write-timeout"`
+	MetadataRefreshFrequency *time.Duration                     `mapstructure:"metadata-refresh-frequency"`
+	Producer                 *KafkaSaramaProducerRepresentation `mapstructure:"producer"`
+	Consumer                 *KafkaSaramaConsumerRepresentation `mapstructure:"consumer"`
+}
+
+// KafkaSaramaProducerRepresentation struct
+type KafkaSaramaProducerRepresentation struct {
+	RequiredAcks    *string `mapstructure:"required-acks"`
+	Compression     *string `mapstructure:"compression"`
+	Idempotent      *bool   `mapstructure:"idempotent"`
+	MaxMessageBytes *int    `mapstructure:"max-message-bytes"`
+}
+
+// KafkaSaramaConsumerRepresentation struct
+type KafkaSaramaConsumerRepresentation struct {
+	SessionTimeout    *time.Duration `mapstructure:"session-timeout"`
+	HeartbeatInterval *time.Duration `mapstructure:"heartbeat-interval"`
+	RebalanceTimeout  *time.Duration `mapstructure:"rebalance-timeout"`
+	FetchMin          *int32         `mapstructure:"fetch-min"`
+	FetchDefault      *int32         `mapstructure:"fetch-default"`
+	FetchMax          *int32         `mapstructure:"fetch-max"`
+	MaxProcessingTime *time.Duration `mapstructure:"max-processing-time"`
+}
+
+// KafkaRetryRepresentation struct
+type KafkaRetryRepresentation struct {
+	MaxAttempts    *int           `mapstructure:"max-attempts"`
+	InitialBackoff *time.Duration `mapstructure:"initial-backoff"`
+	MaxBackoff     *time.Duration `mapstructure:"max-backoff"`
+	Jitter         *float64       `mapstructure:"jitter"`
+}
+
+// KafkaBatchRepresentation struct: thresholds of the batches given to a batch handler. A batch is handled as soon as one of them is reached
+type KafkaBatchRepresentation struct {
+	MaxSize  *int           `mapstructure:"max-size"`
+	MaxBytes *int           `mapstructure:"max-bytes"`
+	Linger   *time.Duration `mapstructure:"linger"`
+}
+
+// KafkaOffsetResetRepresentation struct: reset of the offsets of the consumer group applied once to each partition. Only one of the
+// fields should be set
+type KafkaOffsetResetRepresentation struct {
+	ToTime  *string         `mapstructure:"to-time"`
+	Offsets map[int32]int64 `mapstructure:"offsets"`
+	Shift   *int64          `mapstructure:"shift"`
+}
+
+// KafkaRetryStageRepresentation struct
+type KafkaRetryStageRepresentation struct {
+	Producer *string        `mapstructure:"producer"`
+	Delay    *time.Duration `mapstructure:"delay"`
 }
 
 // Validate validates a KafkaClusterRepresentation instance
@@ -71,6 +240,19 @@ func (kcr *KafkaClusterRepresentation) Validate() error {
 	if err = kcr.Security.Validate(); err != nil {
 		return err
 	}
+	if kcr.TLS != nil {
+		if kcr.TLSEnabled != nil && !*kcr.TLSEnabled {
+			return errors.New("cluster tls configuration can't be used when tls-enabled is false")
+		}
+		if err = kcr.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	if kcr.Sarama != nil {
+		if err = kcr.Sarama.Validate(); err != nil {
+			return err
+		}
+	}
 	if len(kcr.Producers)+len(kcr.Consumers) == 0 {
 		return errors.New("do you really need to configure a cluster with neither producer nor consumer?")
 	}
@@ -78,6 +260,9 @@ func (kcr *KafkaClusterRepresentation) Validate() error {
 		if err = producer.Validate(); err != nil {
 			return err
 		}
+		if err = kcr.validateProducerTuning(producer); err != nil {
+			return err
+		}
 	}
 	for _, consumer := range kcr.Consumers {
 		if err = consumer.Validate(); err != nil {
@@ -87,17 +272,137 @@ func (kcr *KafkaClusterRepresentation) Validate() error {
 	return nil
 }
 
+// validateProducerTuning validates the sarama settings of a producer once they are layered on the ones of the cluster. Transactional
+// producers are not concerned as transactions enforce their own settings
+func (kcr *KafkaClusterRepresentation) validateProducerTuning(producer KafkaProducerRepresentation) error {
+	if producer.TransactionalID != nil {
+		return nil
+	}
+	var config = sarama.NewConfig()
+	if kcr.Sarama != nil {
+		applyProducerTuning(config, kcr.Sarama.Producer)
+	}
+	applyProducerTuning(config, producer.Sarama)
+	if config.Producer.Idempotent && config.Producer.RequiredAcks != sarama.WaitForAll {
+		return errors.New("sarama idempotent producer requires required-acks 'all', including when idempotence is enabled at cluster level")
+	}
+	return nil
+}
+
 // Validate validates a KafkaSecurityRepresentation instance
 func (ksr *KafkaSecurityRepresentation) Validate() error {
+	switch ksr.GetMechanism() {
+	case mechanismOAuthBearer:
+		return ksr.validateTokenSource()
+	case mechanismPlain, mechanismScramSHA256, mechanismScramSHA512:
+		if ksr.Username == nil || *ksr.Username == "" {
+			return errors.New("username is mandatory and should not be empty")
+		}
+		if ksr.Password == nil || *ksr.Password == "" {
+			return errors.New("password is mandatory and should not be empty")
+		}
+	case mechanismNone:
+	default:
+		return errors.New("security mechanism is optional but should be either 'oauthbearer', 'plain', 'scram-sha-256', 'scram-sha-512' or 'none'")
+	}
+	return nil
+}
+
+func (ksr *KafkaSecurityRepresentation) validateTokenSource() error {
+	if ksr.TokenRefreshBefore != nil && *ksr.TokenRefreshBefore < 0 {
+		return errors.New("token-refresh-before is optional but should not be negative")
+	}
+	if ksr.TokenRefreshJitter != nil && *ksr.TokenRefreshJitter < 0 {
+		return errors.New("token-refresh-jitter is optional but should not be negative")
+	}
+	if ksr.TokenFetchRetries != nil && *ksr.TokenFetchRetries < 0 {
+		return errors.New("token-fetch-retries is optional but should not be negative")
+	}
+	if ksr.TokenFetchBackoff != nil && *ksr.TokenFetchBackoff <= 0 {
+		return errors.New("token-fetch-backoff is optional but should be positive")
+	}
+	switch ksr.GetTokenSource() {
+	case tokenSourceFile:
+		if ksr.TokenFile == nil || *ksr.TokenFile == "" {
+			return errors.New("token-file is mandatory and should not be empty")
+		}
+		return nil
+	case tokenSourceClientSecret:
+		if ksr.ClientSecret == nil || *ksr.ClientSecret == "" {
+			return errors.New("client-secret is mandatory and should not be empty")
+		}
+	case tokenSourcePrivateKeyJWT:
+		if ksr.PrivateKeyFile == nil || *ksr.PrivateKeyFile == "" {
+			return errors.New("private-key-file is mandatory and should not be empty")
+		}
+		if ksr.KeyID != nil && *ksr.KeyID == "" {
+			return errors.New("key-id is optional but should not be empty")
+		}
+	default:
+		return errors.New("token-source is optional but should be either 'client-secret', 'private-key-jwt' or 'file'")
+	}
 	if ksr.ClientID == nil || *ksr.ClientID == "" {
 		return errors.New("client-id is mandatory and should not be empty")
 	}
-	if ksr.ClientSecret == nil || *ksr.ClientSecret == "" {
-		return errors.New("client-secret is mandatory and should not be empty")
-	}
 	if ksr.TokenURL == nil || *ksr.TokenURL == "" {
 		return errors.New("token-url is mandatory and should not be empty")
 	}
+	if ksr.AuthStyle != nil {
+		if _, ok := authStyles[*ksr.AuthStyle]; !ok {
+			return errors.New("auth-style is optional but should be either 'auto', 'header' or 'params'")
+		}
+	}
+	if ksr.HTTPTimeout != nil && *ksr.HTTPTimeout <= 0 {
+		return errors.New("http-timeout is optional but should be positive")
+	}
+	if ksr.TokenTLS != nil {
+		return ksr.TokenTLS.Validate()
+	}
+	return nil
+}
+
+// GetMechanism gets the configured security mechanism. OAuth bearer is used by default
+func (ksr *KafkaSecurityRepresentation) GetMechanism() string {
+	if ksr.Mechanism == nil {
+		return mechanismOAuthBearer
+	}
+	return *ksr.Mechanism
+}
+
+// GetTokenSource gets the way access tokens are obtained when the mechanism is oauthbearer. Default is client-secret
+func (ksr *KafkaSecurityRepresentation) GetTokenSource() string {
+	if ksr.TokenSource == nil {
+		return tokenSourceClientSecret
+	}
+	return *ksr.TokenSource
+}
+
+// IsTLSEnabled tells if TLS is used to connect to the cluster. Configuring a tls section enables TLS
+func (kcr *KafkaClusterRepresentation) IsTLSEnabled() bool {
+	return (kcr.TLSEnabled != nil && *kcr.TLSEnabled) || kcr.TLS != nil
+}
+
+// Validate validates a KafkaTLSRepresentation instance
+func (ktr *KafkaTLSRepresentation) Validate() error {
+	if ktr.CAFile != nil && ktr.CAPEM != nil {
+		return errors.New("tls ca-file and ca-pem can't be used together")
+	}
+	if (ktr.CertFile == nil) != (ktr.KeyFile == nil) {
+		return errors.New("tls cert-file and key-file should be used together")
+	}
+	if ktr.KeyPassphrase != nil && ktr.KeyFile == nil {
+		return errors.New("tls key-passphrase can only be used with key-file")
+	}
+	for _, value := range []*string{ktr.CAFile, ktr.CAPEM, ktr.CertFile, ktr.KeyFile, ktr.KeyPassphrase, ktr.ServerName} {
+		if value != nil && *value == "" {
+			return errors.New("tls values are optional but should not be empty")
+		}
+	}
+	if ktr.MinVersion != nil {
+		if _, ok := tlsVersions[*ktr.MinVersion]; !ok {
+			return errors.New("tls min-version is optional but should be either '1.0', '1.1', '1.2' or '1.3'")
+		}
+	}
 	return nil
 }
 
@@ -109,6 +414,96 @@ func (kpr *KafkaProducerRepresentation) Validate() error {
 	if kpr.Topic == nil || *kpr.Topic == "" {
 		return errors.New("producer topic is mandatory and should not be empty")
 	}
+	if kpr.MaxInFlight != nil && *kpr.MaxInFlight < 1 {
+		return errors.New("producer max-in-flight should be greater than 0")
+	}
+	if kpr.TransactionalID != nil && *kpr.TransactionalID == "" {
+		return errors.New("producer transactional id is optional but should not be empty")
+	}
+	if kpr.TransactionalID != nil && kpr.Async != nil && *kpr.Async {
+		return errors.New("producer can't be both asynchronous and transactional")
+	}
+	if kpr.Sarama != nil {
+		if err := kpr.Sarama.Validate(); err != nil {
+			return err
+		}
+		if kpr.TransactionalID != nil && ((kpr.Sarama.Idempotent != nil && !*kpr.Sarama.Idempotent) ||
+			(kpr.Sarama.RequiredAcks != nil && *kpr.Sarama.RequiredAcks != requiredAcksAll)) {
+			return errors.New("transactional producer requires idempotence and required-acks 'all'")
+		}
+	}
+	return nil
+}
+
+// Validate validates the tuning of the sarama client of a cluster
+func (ksr *KafkaSaramaRepresentation) Validate() error {
+	if ksr.ClientID != nil && *ksr.ClientID == "" {
+		return errors.New("sarama client-id is optional but should not be empty")
+	}
+	for _, value := range []*time.Duration{ksr.DialTimeout, ksr.ReadTimeout, ksr.WriteTimeout} {
+		if value != nil && *value <= 0 {
+			return errors.New("sarama timeouts are optional but should be positive")
+		}
+	}
+	if ksr.MetadataRefreshFrequency != nil && *ksr.MetadataRefreshFrequency < 0 {
+		return errors.New("sarama metadata-refresh-frequency is optional but should not be negative")
+	}
+	if ksr.Producer != nil {
+		if err := ksr.Producer.Validate(); err != nil {
+			return err
+		}
+	}
+	if ksr.Consumer != nil {
+		return ksr.Consumer.Validate()
+	}
+	return nil
+}
+
+// Validate validates the tuning of the sarama producers
+func (kspr *KafkaSaramaProducerRepresentation) Validate() error {
+	if kspr.RequiredAcks != nil {
+		if _, ok := requiredAcks[*kspr.RequiredAcks]; !ok {
+			return errors.New("sarama required-acks is optional but should be either 'none', 'leader' or 'all'")
+		}
+		if kspr.Idempotent != nil && *kspr.Idempotent && *kspr.RequiredAcks != requiredAcksAll {
+			return errors.New("sarama idempotent producer requires required-acks 'all'")
+		}
+	}
+	if kspr.Compression != nil {
+		if _, ok := compressionCodecs[*kspr.Compression]; !ok {
+			return errors.New("sarama compression is optional but should be either 'none', 'gzip', 'snappy', 'lz4' or 'zstd'")
+		}
+	}
+	if kspr.MaxMessageBytes != nil && *kspr.MaxMessageBytes <= 0 {
+		return errors.New("sarama max-message-bytes is optional but should be positive")
+	}
+	return nil
+}
+
+// Validate validates the tuning of the sarama consumers
+func (kscr *KafkaSaramaConsumerRepresentation) Validate() error {
+	for _, value := range []*time.Duration{kscr.SessionTimeout, kscr.HeartbeatInterval, kscr.RebalanceTimeout, kscr.MaxProcessingTime} {
+		if value != nil && *value <= 0 {
+			return errors.New("sarama consumer durations are optional but should be positive")
+		}
+	}
+	if kscr.SessionTimeout != nil && kscr.HeartbeatInterval != nil && *kscr.HeartbeatInterval >= *kscr.SessionTimeout {
+		return errors.New("sarama heartbeat-interval should be lower than session-timeout")
+	}
+	for _, value := range []*int32{kscr.FetchMin, kscr.FetchDefault} {
+		if value != nil && *value <= 0 {
+			return errors.New("sarama fetch-min and fetch-default are optional but should be positive")
+		}
+	}
+	if kscr.FetchMax != nil && *kscr.FetchMax < 0 {
+		return errors.New("sarama fetch-max is optional but should not be negative")
+	}
+	if kscr.FetchMin != nil && kscr.FetchDefault != nil && *kscr.FetchMin > *kscr.FetchDefault {
+		return errors.New("sarama fetch-min should not be greater than fetch-default")
+	}
+	if kscr.FetchMax != nil && *kscr.FetchMax > 0 && kscr.FetchDefault != nil && *kscr.FetchDefault > *kscr.FetchMax {
+		return errors.New("sarama fetch-default should not be greater than fetch-max")
+	}
 	return nil
 }
 
@@ -127,8 +522,148 @@ func (kcr *KafkaConsumerRepresentation) Validate() error {
 		return errors.New("consumer failure producer is optional but should not be empty")
 	}
 	if kcr.InitialOffset != nil && !(*kcr.InitialOffset == offsetOldestParam || *kcr.InitialOffset == offsetNewestParam) {
-		return errors.New("consumer initial offset is optional but should be either 'oldest' or 'newest'")
+		if _, ok := parseOffsetTime(*kcr.InitialOffset); !ok {
+			return errors.New("consumer initial offset is optional but should be either 'oldest', 'newest', an RFC3339 timestamp or a negative duration")
+		}
+	}
+	if kcr.FailurePolicy != nil && !slices.Contains([]string{failurePolicyRestart, failurePolicyStop, failurePolicyExit}, *kcr.FailurePolicy) {
+		return errors.New("consumer failure policy is optional but should be either 'restart', 'stop' or 'exit'")
+	}
+	if kcr.RestartBackoff != nil && *kcr.RestartBackoff <= 0 {
+		return errors.New("consumer restart backoff is optional but should be positive")
+	}
+	if kcr.RestartMaxBackoff != nil && *kcr.RestartMaxBackoff <= 0 {
+		return errors.New("consumer restart max backoff is optional but should be positive")
+	}
+	if kcr.RestartBackoff != nil && kcr.RestartMaxBackoff != nil && *kcr.RestartMaxBackoff < *kcr.RestartBackoff {
+		return errors.New("consumer restart max backoff should not be lower than restart backoff")
+	}
+	if kcr.TransactionalProducer != nil && *kcr.TransactionalProducer == "" {
+		return errors.New("consumer transactional producer is optional but should not be empty")
+	}
+	if kcr.IsolationLevel != nil && !(*kcr.IsolationLevel == isolationLevelReadUncommitted || *kcr.IsolationLevel == isolationLevelReadCommitted) {
+		return errors.New("consumer isolation level is optional but should be either 'read_uncommitted' or 'read_committed'")
+	}
+	for _, strategy := range kcr.RebalanceStrategies {
+		if strategy == "cooperative-sticky" {
+			return errors.New("consumer rebalance strategy 'cooperative-sticky' is not supported by sarama")
+		}
+		if _, ok := rebalanceStrategies[strategy]; !ok {
+			return errors.New("consumer rebalance strategies are optional but should be either 'range', 'round-robin' or 'sticky'")
+		}
+	}
+	if kcr.GroupInstanceID != nil && *kcr.GroupInstanceID == "" {
+		return errors.New("consumer group instance id is optional but should not be empty")
+	}
+	if kcr.RackID != nil && *kcr.RackID == "" {
+		return errors.New("consumer rack id is optional but should not be empty")
+	}
+	if kcr.Workers != nil && *kcr.Workers < 1 {
+		return errors.New("consumer workers is optional but should be at least 1")
+	}
+	if kcr.Workers != nil && *kcr.Workers > 1 && kcr.TransactionalProducer != nil {
+		return errors.New("consumer workers can't be used with a transactional producer")
+	}
+	if kcr.MaxInFlight != nil && (kcr.Workers == nil || *kcr.MaxInFlight < *kcr.Workers) {
+		return errors.New("consumer max in flight is optional but requires workers and should not be lower than workers")
+	}
+	if kcr.Retry != nil {
+		if err := kcr.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if kcr.Batch != nil {
+		if (kcr.Workers != nil && *kcr.Workers > 1) || kcr.TransactionalProducer != nil {
+			return errors.New("consumer batch can't be used with workers or a transactional producer")
+		}
+		if err := kcr.Batch.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, stage := range kcr.RetryStages {
+		if err := stage.Validate(); err != nil {
+			return err
+		}
+	}
+	if kcr.ResetOffsets != nil {
+		if err := kcr.ResetOffsets.Validate(); err != nil {
+			return err
+		}
+	}
+	if kcr.Sarama != nil {
+		return kcr.Sarama.Validate()
 	}
 
 	return nil
 }
+
+// Validate validates a KafkaRetryRepresentation instance
+func (krr *KafkaRetryRepresentation) Validate() error {
+	if krr.MaxAttempts == nil || *krr.MaxAttempts < 1 {
+		return errors.New("retry max attempts is mandatory and should be at least 1")
+	}
+	if krr.InitialBackoff != nil && *krr.InitialBackoff <= 0 {
+		return errors.New("retry initial backoff is optional but should be positive")
+	}
+	if krr.MaxBackoff != nil && *krr.MaxBackoff <= 0 {
+		return errors.New("retry max backoff is optional but should be positive")
+	}
+	if krr.InitialBackoff != nil && krr.MaxBackoff != nil && *krr.MaxBackoff < *krr.InitialBackoff {
+		return errors.New("retry max backoff should not be lower than initial backoff")
+	}
+	if krr.Jitter != nil && (*krr.Jitter < 0 || *krr.Jitter > 1) {
+		return errors.New("retry jitter is optional but should be between 0 and 1")
+	}
+	return nil
+}
+
+// Validate validates a KafkaBatchRepresentation instance
+func (kbr *KafkaBatchRepresentation) Validate() error {
+	if kbr.MaxSize != nil && *kbr.MaxSize < 1 {
+		return errors.New("batch max size is optional but should be at least 1")
+	}
+	if kbr.MaxBytes != nil && *kbr.MaxBytes < 1 {
+		return errors.New("batch max bytes is optional but should be positive")
+	}
+	if kbr.Linger != nil && *kbr.Linger <= 0 {
+		return errors.New("batch linger is optional but should be positive")
+	}
+	return nil
+}
+
+// Validate validates a KafkaOffsetResetRepresentation instance
+func (korr *KafkaOffsetResetRepresentation) Validate() error {
+	var count = 0
+	if korr.ToTime != nil {
+		if _, ok := parseOffsetTime(*korr.ToTime); !ok {
+			return errors.New("reset offsets to time is optional but should be an RFC3339 timestamp or a negative duration")
+		}
+		count++
+	}
+	if korr.Offsets != nil {
+		for _, offset := range korr.Offsets {
+			if offset < 0 {
+				return errors.New("reset offsets are optional but should not be negative")
+			}
+		}
+		count++
+	}
+	if korr.Shift != nil {
+		count++
+	}
+	if count != 1 {
+		return errors.New("reset offsets should have exactly one of to-time, offsets or shift")
+	}
+	return nil
+}
+
+// Validate validates a KafkaRetryStageRepresentation instance
+func (krsr *KafkaRetryStageRepresentation) Validate() error {
+	if krsr.Producer == nil || *krsr.Producer == "" {
+		return errors.New("retry stage producer is mandatory and should not be empty")
+	}
+	if krsr.Delay == nil || *krsr.Delay <= 0 {
+		return errors.New("retry stage delay is mandatory and should be positive")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package kafkauniverse
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,9 +23,11 @@ func createValidKafkaClusterRepresentation() KafkaClusterRepresentation {
 		},
 		Producers: []KafkaProducerRepresentation{
 			{
-				ID:      new("producer-1"),
-				Enabled: new(true),
-				Topic:   new("topic-producer-1"),
+				ID:          new("producer-1"),
+				Enabled:     new(true),
+				Topic:       new("topic-producer-1"),
+				Async:       new(false),
+				MaxInFlight: new(100),
 			},
 		},
 		Consumers: []KafkaConsumerRepresentation{
@@ -35,6 +38,8 @@ func createValidKafkaClusterRepresentation() KafkaClusterRepresentation {
 				ConsumerGroupName: new("consumer-group-1"),
 				FailureProducer:   new("producer-1"),
 				InitialOffset:     nil,
+				Workers:           new(4),
+				MaxInFlight:       new(16),
 			},
 			{
 				ID:                new("consumer-2"),
@@ -43,6 +48,22 @@ func createValidKafkaClusterRepresentation() KafkaClusterRepresentation {
 				ConsumerGroupName: new("consumer-group-2"),
 				FailureProducer:   new("producer-1"),
 				InitialOffset:     new("newest"),
+				FailurePolicy:     new("stop"),
+				RestartBackoff:    new(time.Second),
+				RestartMaxBackoff: new(time.Minute),
+				Retry: &KafkaRetryRepresentation{
+					MaxAttempts:    new(3),
+					InitialBackoff: new(time.Second),
+					MaxBackoff:     new(time.Minute),
+					Jitter:         new(0.2),
+				},
+				RetryStages: []KafkaRetryStageRepresentation{
+					{Producer: new("producer-1"), Delay: new(30 * time.Second)},
+				},
+				IsolationLevel:      new("read_committed"),
+				RebalanceStrategies: []string{"sticky", "range"},
+				GroupInstanceID:     new("consumer-2-instance"),
+				RackID:              new("zone-a"),
 			},
 		},
 	}
@@ -52,9 +73,76 @@ func TestValidateCluster(t *testing.T) {
 	var cluster = createValidKafkaClusterRepresentation()
 	assert.Nil(t, cluster.Validate())
 
+	t.Run("Security mechanisms", func(t *testing.T) {
+		for _, mechanism := range []string{"plain", "scram-sha-256", "scram-sha-512"} {
+			var security = KafkaSecurityRepresentation{Mechanism: &mechanism, Username: new("username"), Password: new("password")}
+			assert.Nil(t, security.Validate())
+		}
+		var security = KafkaSecurityRepresentation{Mechanism: new("none")}
+		assert.Nil(t, security.Validate())
+	})
+	t.Run("OAuth token endpoint", func(t *testing.T) {
+		var security = *createValidKafkaClusterRepresentation().Security
+		security.Scopes = []string{"kafka"}
+		security.EndpointParams = map[string]string{"audience": "kafka-cluster"}
+		security.AuthStyle = new("params")
+		security.HTTPTimeout = new(5 * time.Second)
+		security.TokenTLS = &KafkaTLSRepresentation{CAFile: new("ca.pem")}
+		security.Extensions = map[string]string{"logicalCluster": "lkc-1"}
+		assert.Nil(t, security.Validate())
+	})
+	t.Run("Batch", func(t *testing.T) {
+		var consumer = createValidKafkaClusterRepresentation().Consumers[1]
+		consumer.Batch = &KafkaBatchRepresentation{MaxSize: new(500), MaxBytes: new(1048576), Linger: new(time.Second)}
+		assert.Nil(t, consumer.Validate())
+	})
+	t.Run("Offset reset", func(t *testing.T) {
+		var consumer = createValidKafkaClusterRepresentation().Consumers[1]
+		for _, initialOffset := range []string{"oldest", "2024-03-01T10:00:00Z", "-2h"} {
+			consumer.InitialOffset = new(initialOffset)
+			assert.Nil(t, consumer.Validate())
+		}
+		for _, reset := range []KafkaOffsetResetRepresentation{{ToTime: new("2024-03-01T10:00:00+01:00")}, {ToTime: new("-30m")},
+			{Offsets: map[int32]int64{0: 0, 1: 1200}}, {Shift: new(int64(-100))}} {
+			consumer.ResetOffsets = &reset
+			assert.Nil(t, consumer.Validate())
+		}
+	})
+	t.Run("OAuth token sources", func(t *testing.T) {
+		var security = KafkaSecurityRepresentation{TokenSource: new("private-key-jwt"), ClientID: new("id"), TokenURL: new("https://token"),
+			PrivateKeyFile: new("client.key"), KeyID: new("key-1")}
+		assert.Nil(t, security.Validate())
+		security = KafkaSecurityRepresentation{TokenSource: new("file"), TokenFile: new("/var/run/secrets/tokens/kafka")}
+		assert.Nil(t, security.Validate())
+	})
+	t.Run("Sarama tuning", func(t *testing.T) {
+		var tunedCluster = createValidKafkaClusterRepresentation()
+		tunedCluster.Sarama = &KafkaSaramaRepresentation{ClientID: new("my-app"), DialTimeout: new(5 * time.Second),
+			MetadataRefreshFrequency: new(time.Duration(0)),
+			Producer:                 &KafkaSaramaProducerRepresentation{RequiredAcks: new("all"), Compression: new("snappy"), Idempotent: new(true)},
+			Consumer:                 &KafkaSaramaConsumerRepresentation{SessionTimeout: new(30 * time.Second), HeartbeatInterval: new(3 * time.Second)}}
+		tunedCluster.Producers[0].Sarama = &KafkaSaramaProducerRepresentation{MaxMessageBytes: new(2000000)}
+		tunedCluster.Consumers[0].Sarama = &KafkaSaramaConsumerRepresentation{FetchMin: new(int32(1)), FetchDefault: new(int32(1024)), FetchMax: new(int32(0))}
+		assert.Nil(t, tunedCluster.Validate())
+
+		// Idempotence enabled by the producer overrides the required acks of the cluster
+		tunedCluster.Sarama.Producer = &KafkaSaramaProducerRepresentation{RequiredAcks: new("leader")}
+		tunedCluster.Producers[0].Sarama = &KafkaSaramaProducerRepresentation{Idempotent: new(true)}
+		assert.Nil(t, tunedCluster.Validate())
+	})
+	t.Run("TLS", func(t *testing.T) {
+		var tlsCluster = createValidKafkaClusterRepresentation()
+		tlsCluster.TLSEnabled = nil
+		tlsCluster.TLS = &KafkaTLSRepresentation{CAFile: new("ca.pem"), CertFile: new("client.pem"), KeyFile: new("client.key"), KeyPassphrase: new("file:/run/secrets/key"),
+			ServerName: new("broker"), MinVersion: new("1.3"), InsecureSkipVerify: new(false)}
+		assert.Nil(t, tlsCluster.Validate())
+		assert.True(t, tlsCluster.IsTLSEnabled())
+		assert.False(t, cluster.IsTLSEnabled())
+	})
+
 	var emptyString = new("")
 	var invalidCases []KafkaClusterRepresentation
-	for range 36 {
+	for range 113 {
 		invalidCases = append(invalidCases, createValidKafkaClusterRepresentation())
 	}
 	invalidCases[0].ID = nil
@@ -94,6 +182,100 @@ func TestValidateCluster(t *testing.T) {
 	invalidCases[33].Consumers[1].FailureProducer = emptyString
 	invalidCases[34].Consumers[1].InitialOffset = new("not oldest nor newest")
 	invalidCases[35].Consumers[0].InitialOffset = emptyString
+	invalidCases[36].Consumers[0].FailurePolicy = new("ignore")
+	invalidCases[37].Consumers[0].RestartBackoff = new(time.Duration(0))
+	invalidCases[38].Consumers[0].RestartMaxBackoff = new(-time.Second)
+	invalidCases[39].Consumers[0].RestartBackoff = new(time.Minute)
+	invalidCases[39].Consumers[0].RestartMaxBackoff = new(time.Second)
+	invalidCases[40].Consumers[1].FailurePolicy = emptyString
+	invalidCases[41].Consumers[1].Retry.MaxAttempts = nil
+	invalidCases[42].Consumers[1].Retry.MaxAttempts = new(0)
+	invalidCases[43].Consumers[1].Retry.InitialBackoff = new(time.Duration(0))
+	invalidCases[44].Consumers[1].Retry.MaxBackoff = new(time.Duration(-1))
+	invalidCases[45].Consumers[1].Retry.MaxBackoff = new(time.Millisecond)
+	invalidCases[46].Consumers[1].Retry.Jitter = new(1.5)
+	invalidCases[47].Consumers[1].RetryStages[0].Producer = nil
+	invalidCases[48].Consumers[1].RetryStages[0].Producer = emptyString
+	invalidCases[49].Consumers[1].RetryStages[0].Delay = nil
+	invalidCases[50].Consumers[1].RetryStages[0].Delay = new(time.Duration(0))
+	invalidCases[51].Producers[0].MaxInFlight = new(0)
+	invalidCases[52].Producers[0].TransactionalID = emptyString
+	invalidCases[53].Producers[0].TransactionalID = new("transactional-id")
+	invalidCases[53].Producers[0].Async = new(true)
+	invalidCases[54].Consumers[0].TransactionalProducer = emptyString
+	invalidCases[55].Consumers[0].IsolationLevel = new("serializable")
+	invalidCases[56].Security.Mechanism = new("kerberos")
+	invalidCases[57].Security.Mechanism = new("plain")
+	invalidCases[58].Security.Mechanism = new("scram-sha-256")
+	invalidCases[58].Security.Username = new("username")
+	invalidCases[59].Security.Mechanism = new("scram-sha-512")
+	invalidCases[59].Security.Username = emptyString
+	invalidCases[60].TLS = &KafkaTLSRepresentation{}
+	invalidCases[61].TLSEnabled = nil
+	invalidCases[61].TLS = &KafkaTLSRepresentation{CAFile: new("ca.pem"), CAPEM: new("-----BEGIN CERTIFICATE-----")}
+	invalidCases[62].TLSEnabled = nil
+	invalidCases[62].TLS = &KafkaTLSRepresentation{CertFile: new("client.pem")}
+	invalidCases[63].TLSEnabled = nil
+	invalidCases[63].TLS = &KafkaTLSRepresentation{ServerName: emptyString}
+	invalidCases[64].TLSEnabled = nil
+	invalidCases[64].TLS = &KafkaTLSRepresentation{MinVersion: new("1.4")}
+	invalidCases[65].Security.AuthStyle = new("basic")
+	invalidCases[66].Security.HTTPTimeout = new(time.Duration(0))
+	invalidCases[67].Security.TokenTLS = &KafkaTLSRepresentation{CertFile: new("client.pem")}
+	invalidCases[68].Security.TokenSource = new("password")
+	invalidCases[69].Security.TokenSource = new("private-key-jwt")
+	invalidCases[70].Security.TokenSource = new("private-key-jwt")
+	invalidCases[70].Security.PrivateKeyFile = new("client.key")
+	invalidCases[70].Security.KeyID = emptyString
+	invalidCases[71].Security.TokenSource = new("file")
+	invalidCases[72].Security.TokenSource = new("file")
+	invalidCases[72].Security.TokenFile = emptyString
+	invalidCases[73].Security.TokenRefreshBefore = new(-time.Second)
+	invalidCases[74].Security.TokenRefreshJitter = new(-time.Second)
+	invalidCases[75].Security.TokenFetchRetries = new(-1)
+	invalidCases[76].Security.TokenFetchBackoff = new(time.Duration(0))
+	invalidCases[77].TLSEnabled = nil
+	invalidCases[77].TLS = &KafkaTLSRepresentation{KeyPassphrase: new("env:KEY_PASSPHRASE")}
+	invalidCases[78].TLSEnabled = nil
+	invalidCases[78].TLS = &KafkaTLSRepresentation{CertFile: new("client.pem"), KeyFile: new("client.key"), KeyPassphrase: emptyString}
+	invalidCases[79].Sarama = &KafkaSaramaRepresentation{ClientID: emptyString}
+	invalidCases[80].Sarama = &KafkaSaramaRepresentation{DialTimeout: new(time.Duration(0))}
+	invalidCases[81].Sarama = &KafkaSaramaRepresentation{MetadataRefreshFrequency: new(-time.Second)}
+	invalidCases[82].Sarama = &KafkaSaramaRepresentation{Producer: &KafkaSaramaProducerRepresentation{RequiredAcks: new("1")}}
+	invalidCases[83].Sarama = &KafkaSaramaRepresentation{Consumer: &KafkaSaramaConsumerRepresentation{FetchMin: new(int32(0))}}
+	invalidCases[84].Producers[0].Sarama = &KafkaSaramaProducerRepresentation{Compression: new("brotli")}
+	invalidCases[85].Producers[0].Sarama = &KafkaSaramaProducerRepresentation{MaxMessageBytes: new(0)}
+	invalidCases[86].Producers[0].Sarama = &KafkaSaramaProducerRepresentation{Idempotent: new(true), RequiredAcks: new("leader")}
+	invalidCases[87].Producers[0].TransactionalID = new("txn")
+	invalidCases[87].Producers[0].Async = nil
+	invalidCases[87].Producers[0].Sarama = &KafkaSaramaProducerRepresentation{Idempotent: new(false)}
+	invalidCases[88].Consumers[0].Sarama = &KafkaSaramaConsumerRepresentation{SessionTimeout: new(10 * time.Second), HeartbeatInterval: new(10 * time.Second)}
+	invalidCases[89].Consumers[0].Sarama = &KafkaSaramaConsumerRepresentation{MaxProcessingTime: new(time.Duration(0))}
+	invalidCases[90].Consumers[0].Sarama = &KafkaSaramaConsumerRepresentation{FetchMin: new(int32(2048)), FetchDefault: new(int32(1024))}
+	invalidCases[91].Consumers[1].Sarama = &KafkaSaramaConsumerRepresentation{FetchDefault: new(int32(4096)), FetchMax: new(int32(1024))}
+	invalidCases[92].Consumers[0].RebalanceStrategies = []string{"cooperative-sticky"}
+	invalidCases[93].Consumers[0].RebalanceStrategies = []string{"range", "roundrobin"}
+	invalidCases[94].Consumers[0].GroupInstanceID = emptyString
+	invalidCases[95].Consumers[1].RackID = emptyString
+	invalidCases[96].Consumers[1].RebalanceStrategies = []string{""}
+	invalidCases[97].Consumers[0].Workers = new(0)
+	invalidCases[98].Consumers[0].TransactionalProducer = new("producer-1")
+	invalidCases[99].Consumers[1].MaxInFlight = new(10)
+	invalidCases[100].Consumers[0].MaxInFlight = new(2)
+	invalidCases[101].Consumers[1].Batch = &KafkaBatchRepresentation{MaxSize: new(0)}
+	invalidCases[102].Consumers[1].Batch = &KafkaBatchRepresentation{MaxBytes: new(-1)}
+	invalidCases[103].Consumers[1].Batch = &KafkaBatchRepresentation{Linger: new(time.Duration(0))}
+	invalidCases[104].Consumers[0].Batch = &KafkaBatchRepresentation{}
+	invalidCases[105].Consumers[1].TransactionalProducer = new("producer-1")
+	invalidCases[105].Consumers[1].Batch = &KafkaBatchRepresentation{}
+	invalidCases[106].Consumers[1].InitialOffset = new("2h")
+	invalidCases[107].Consumers[1].ResetOffsets = &KafkaOffsetResetRepresentation{}
+	invalidCases[108].Consumers[1].ResetOffsets = &KafkaOffsetResetRepresentation{ToTime: new("-1h"), Shift: new(int64(-10))}
+	invalidCases[109].Consumers[1].ResetOffsets = &KafkaOffsetResetRepresentation{ToTime: new("yesterday")}
+	invalidCases[110].Consumers[1].ResetOffsets = &KafkaOffsetResetRepresentation{Offsets: map[int32]int64{0: -1}}
+	invalidCases[111].Consumers[0].ResetOffsets = &KafkaOffsetResetRepresentation{Offsets: map[int32]int64{}, Shift: new(int64(5))}
+	invalidCases[112].Sarama = &KafkaSaramaRepresentation{Producer: &KafkaSaramaProducerRepresentation{Idempotent: new(true)}}
+	invalidCases[112].Producers[0].Sarama = &KafkaSaramaProducerRepresentation{RequiredAcks: new("leader")}
 
 	for idx, value := range invalidCases {
 		t.Run(fmt.Sprintf("Invalid case #%d", idx), func(t *testing.T) {
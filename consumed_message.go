@@ -2,19 +2,50 @@ package kafkauniverse
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
-// KafkaMessage interface
-type KafkaMessage interface {
-	GetContent() any
+// Headers added to the messages forwarded to retry stages and failure topics
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderOriginalTimestamp = "x-original-timestamp"
+	HeaderRetryAttempt      = "x-retry-attempt"
+	HeaderExceptionMessage  = "x-exception-message"
+	HeaderExceptionType     = "x-exception-type"
+)
+
+var forwardedHeaders = []string{HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset, HeaderOriginalTimestamp,
+	HeaderRetryAttempt, HeaderExceptionMessage, HeaderExceptionType}
+
+// KafkaMessageMetadata gives access to the consumed message as received from Kafka
+type KafkaMessageMetadata interface {
 	GetOffset() int64
 	GetPartition() int32
 	GetTopic() string
+	GetKey() []byte
+	GetHeaders() map[string]string
+	GetHeader(name string) (string, bool)
+	GetTimestamp() time.Time
+	GetBlockTimestamp() time.Time
+	GetRawValue() []byte
+}
+
+// KafkaMessage interface
+type KafkaMessage interface {
+	KafkaMessageMetadata
+	GetContent() any
+	GetAttempt() int
 	Commit()
 	CommitWithMessage(message string)
 	SendToFailureTopic() error
+	SendToFailureTopicWithError(cause error) error
+	GetTransactionalProducer() Producer
 	AbortConsuming()
 }
 
@@ -23,7 +54,12 @@ type consumedMessage struct {
 	content  any
 	consumer *consumer
 	session  sarama.ConsumerGroupSession
-	abort    bool
+	// offsets is set when the messages of the claim are processed concurrently
+	offsets *claimOffsets
+	abort   bool
+	attempt int
+	// previousAttempts is the number of handler invocations already done by previous retry stages
+	previousAttempts int
 }
 
 // GetContent returns the content of the consumed message. Mappers have already been applied to the original received content.
@@ -46,18 +82,70 @@ func (cm *consumedMessage) GetTopic() string {
 	return cm.msg.Topic
 }
 
+// GetKey gets the key of the message. It is nil when the message has been produced without key
+func (cm *consumedMessage) GetKey() []byte {
+	return cm.msg.Key
+}
+
+// GetHeaders gets the headers of the message. When a header is present several times, its last value is returned
+func (cm *consumedMessage) GetHeaders() map[string]string {
+	var headers = make(map[string]string, len(cm.msg.Headers))
+	for _, header := range cm.msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return headers
+}
+
+// GetHeader gets the value of a header of the message and tells if this header is present
+func (cm *consumedMessage) GetHeader(name string) (string, bool) {
+	return findHeader(cm.msg.Headers, name)
+}
+
+// GetTimestamp gets the timestamp of the message
+func (cm *consumedMessage) GetTimestamp() time.Time {
+	return cm.msg.Timestamp
+}
+
+// GetBlockTimestamp gets the timestamp of the outer (compressed) block of the message
+func (cm *consumedMessage) GetBlockTimestamp() time.Time {
+	return cm.msg.BlockTimestamp
+}
+
+// GetRawValue gets the value of the message as received from Kafka, before mappers are applied
+func (cm *consumedMessage) GetRawValue() []byte {
+	return cm.msg.Value
+}
+
+// GetAttempt gets the number of the current handler invocation for this message, starting from 1. Invocations done by
+// previous retry stages are included
+func (cm *consumedMessage) GetAttempt() int {
+	return cm.previousAttempts + cm.attempt
+}
+
 // Commit confirms that the consumed message has been processed
 func (cm *consumedMessage) Commit() {
 	cm.CommitWithMessage("")
 }
 
-// CommitWithMessage confirms that the consumed message has been processed
+// CommitWithMessage confirms that the consumed message has been processed. When messages are processed concurrently, offsets are only
+// marked once all the previous messages of the partition are committed
 func (cm *consumedMessage) CommitWithMessage(message string) {
+	if cm.offsets != nil {
+		cm.offsets.commit(cm.msg.Offset, message)
+		return
+	}
 	cm.session.MarkMessage(cm.msg, message)
 }
 
-// SendToFailureTopic sends the consumed message to the failure topic if it is configured. This call will be
+// SendToFailureTopic sends the consumed message to the failure topic if it is configured. The key and headers of the message are kept
+// and headers describing its origin are added
 func (cm *consumedMessage) SendToFailureTopic() error {
+	return cm.SendToFailureTopicWithError(nil)
+}
+
+// SendToFailureTopicWithError sends the consumed message to the failure topic if it is configured. Headers describing the error which
+// caused the failure are added to the ones added by SendToFailureTopic
+func (cm *consumedMessage) SendToFailureTopicWithError(cause error) error {
 	if cm.consumer.failureProducerName == nil {
 		// No automatic failure mechanism configured
 		return nil
@@ -65,10 +153,78 @@ func (cm *consumedMessage) SendToFailureTopic() error {
 	if cm.consumer.failureProducer == nil {
 		return fmt.Errorf("failed to send message to uninitialized producer %s", *cm.consumer.failureProducerName)
 	}
-	return cm.consumer.failureProducer.SendMessageBytes(cm.msg.Value)
+	return cm.forward(cm.consumer.failureProducer, cause)
+}
+
+// GetTransactionalProducer gets the transactional producer of the consumer, or nil if the consumer is not transactional. Messages sent
+// with this producer by the handler are committed with the offset of the consumed message
+func (cm *consumedMessage) GetTransactionalProducer() Producer {
+	if cm.consumer.transactionalProducer == nil {
+		return nil
+	}
+	return cm.consumer.transactionalProducer
 }
 
 // AbortConsuming let the consuming main process stops. The abort command will be taken into account only if the message handler returns an error
 func (cm *consumedMessage) AbortConsuming() {
 	cm.abort = true
 }
+
+// routeFailure sends the consumed message to the next retry stage if any, to the failure topic otherwise
+func (cm *consumedMessage) routeFailure(cause error) error {
+	if cm.consumer.retryProducer != nil {
+		return cm.forward(cm.consumer.retryProducer, cause)
+	}
+	return cm.SendToFailureTopicWithError(cause)
+}
+
+// forward sends the consumed message to the given producer, keeping track of its origin and of the cause of the failure in headers.
+// When the message has already been forwarded (consumed from a retry stage), its original location is kept, as well as its last known
+// error when no cause is given
+func (cm *consumedMessage) forward(target *producer, cause error) error {
+	var headers = map[string]string{
+		HeaderOriginalTopic:     cm.msg.Topic,
+		HeaderOriginalPartition: strconv.FormatInt(int64(cm.msg.Partition), 10),
+		HeaderOriginalOffset:    strconv.FormatInt(cm.msg.Offset, 10),
+		HeaderOriginalTimestamp: strconv.FormatInt(cm.msg.Timestamp.UnixMilli(), 10),
+	}
+	var forwarded = &sarama.ProducerMessage{
+		Value: sarama.ByteEncoder(cm.msg.Value),
+	}
+	if cm.msg.Key != nil {
+		forwarded.Key = sarama.ByteEncoder(cm.msg.Key)
+	}
+	for _, header := range cm.msg.Headers {
+		var key = string(header.Key)
+		if !slices.Contains(forwardedHeaders, key) {
+			forwarded.Headers = append(forwarded.Headers, *header)
+		} else if _, ok := headers[key]; ok && cm.consumer.parent != nil {
+			// Message consumed from a retry stage: keep its original location
+			headers[key] = string(header.Value)
+		} else if cause == nil && (key == HeaderExceptionMessage || key == HeaderExceptionType) {
+			// No new cause: keep the last known error
+			headers[key] = string(header.Value)
+		}
+	}
+	headers[HeaderRetryAttempt] = strconv.Itoa(cm.GetAttempt())
+	if cause != nil {
+		headers[HeaderExceptionMessage] = cause.Error()
+		headers[HeaderExceptionType] = fmt.Sprintf("%T", cause)
+	}
+	for _, key := range forwardedHeaders {
+		if value, ok := headers[key]; ok {
+			forwarded.Headers = append(forwarded.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+	return target.sendMessage(forwarded)
+}
+
+// retriedAttempts reads the number of handler invocations already done by previous retry stages
+func retriedAttempts(msg *sarama.ConsumerMessage) int {
+	if value, ok := findHeader(msg.Headers, HeaderRetryAttempt); ok {
+		if attempts, err := strconv.Atoi(value); err == nil {
+			return attempts
+		}
+	}
+	return 0
+}
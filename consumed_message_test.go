@@ -1,7 +1,9 @@
 package kafkauniverse
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/cloudtrust/kafka-client/mock"
@@ -42,6 +44,35 @@ func TestConsumedMessage(t *testing.T) {
 	t.Run("GetTopic", func(t *testing.T) {
 		assert.Equal(t, topic, km.GetTopic())
 	})
+	t.Run("GetAttempt", func(t *testing.T) {
+		assert.Equal(t, 0, km.GetAttempt())
+	})
+	t.Run("Metadata", func(t *testing.T) {
+		var now = time.Now()
+		var metadata = &consumedMessage{
+			msg: &sarama.ConsumerMessage{
+				Key:            []byte("a-key"),
+				Value:          []byte("raw value"),
+				Timestamp:      now,
+				BlockTimestamp: now.Add(-time.Second),
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte("correlation"), Value: []byte("abc")},
+					{Key: []byte("version"), Value: []byte("1")},
+					{Key: []byte("version"), Value: []byte("2")},
+				},
+			},
+		}
+		assert.Equal(t, []byte("a-key"), metadata.GetKey())
+		assert.Equal(t, []byte("raw value"), metadata.GetRawValue())
+		assert.Equal(t, now, metadata.GetTimestamp())
+		assert.Equal(t, now.Add(-time.Second), metadata.GetBlockTimestamp())
+		assert.Equal(t, map[string]string{"correlation": "abc", "version": "2"}, metadata.GetHeaders())
+		var value, ok = metadata.GetHeader("correlation")
+		assert.True(t, ok)
+		assert.Equal(t, "abc", value)
+		_, ok = metadata.GetHeader("unknown")
+		assert.False(t, ok)
+	})
 	t.Run("Commit", func(t *testing.T) {
 		mockConsumerGroupSession.EXPECT().MarkMessage(km.msg, "")
 		km.Commit()
@@ -55,9 +86,73 @@ func TestConsumedMessage(t *testing.T) {
 		km.consumer.failureProducer = nil
 		assert.Contains(t, km.SendToFailureTopic().Error(), "uninitialized producer")
 	})
+	t.Run("Send to failure topic", func(t *testing.T) {
+		var mockProducer = mock.NewSyncProducer(mockCtrl)
+		km.consumer.failureProducerName = new("failure-topic")
+		km.consumer.failureProducer = &producer{initialized: true, enabled: true, topic: new("failure-topic"), producer: mockProducer}
+		km.msg.Key = []byte("a-key")
+		km.msg.Timestamp = time.UnixMilli(1700000000000)
+		km.msg.Headers = []*sarama.RecordHeader{{Key: []byte("correlation"), Value: []byte("abc")}}
+		defer func() { km.msg.Key, km.msg.Headers = nil, nil }()
+
+		t.Run("Without error", func(t *testing.T) {
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				assert.Equal(t, "failure-topic", msg.Topic)
+				assert.Equal(t, sarama.ByteEncoder("a-key"), msg.Key)
+				assert.Equal(t, map[string]string{
+					"correlation":           "abc",
+					HeaderOriginalTopic:     topic,
+					HeaderOriginalPartition: "4",
+					HeaderOriginalOffset:    "456789",
+					HeaderOriginalTimestamp: "1700000000000",
+					HeaderRetryAttempt:      "0",
+				}, headersAsMap(msg.Headers))
+				return 0, 0, nil
+			})
+			assert.Nil(t, km.SendToFailureTopic())
+		})
+		t.Run("With error", func(t *testing.T) {
+			var cause = errors.New("invalid content")
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				var headers = headersAsMap(msg.Headers)
+				assert.Equal(t, cause.Error(), headers[HeaderExceptionMessage])
+				assert.Equal(t, "*errors.errorString", headers[HeaderExceptionType])
+				return 0, 0, cause
+			})
+			assert.Equal(t, cause, km.SendToFailureTopicWithError(cause))
+		})
+		t.Run("Last known error is kept", func(t *testing.T) {
+			km.msg.Headers = append(km.msg.Headers, &sarama.RecordHeader{Key: []byte(HeaderExceptionMessage), Value: []byte("timeout")},
+				&sarama.RecordHeader{Key: []byte(HeaderExceptionType), Value: []byte("*net.OpError")})
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				var headers = headersAsMap(msg.Headers)
+				assert.Equal(t, "timeout", headers[HeaderExceptionMessage])
+				assert.Equal(t, "*net.OpError", headers[HeaderExceptionType])
+				assert.Len(t, msg.Headers, 8)
+				return 0, 0, nil
+			})
+			assert.Nil(t, km.SendToFailureTopic())
+
+			var cause = errors.New("invalid content")
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				assert.Equal(t, cause.Error(), headersAsMap(msg.Headers)[HeaderExceptionMessage])
+				assert.Len(t, msg.Headers, 8)
+				return 0, 0, nil
+			})
+			assert.Nil(t, km.SendToFailureTopicWithError(cause))
+		})
+	})
 	t.Run("Abort", func(t *testing.T) {
 		assert.False(t, km.abort)
 		km.AbortConsuming()
 		assert.True(t, km.abort)
 	})
 }
+
+func headersAsMap(headers []sarama.RecordHeader) map[string]string {
+	var res = map[string]string{}
+	for _, header := range headers {
+		res[string(header.Key)] = string(header.Value)
+	}
+	return res
+}
@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -19,9 +22,24 @@ type KafkaMessageHandler func(context.Context, KafkaMessage) error
 // KafkaMessageMapper function type
 type KafkaMessageMapper func(ctx context.Context, messageOffset int64, in any) (any, error)
 
+// KafkaMessageMapperWithMetadata function type. Mappers of this type can use the key, headers and timestamps of the consumed message
+type KafkaMessageMapperWithMetadata func(ctx context.Context, metadata KafkaMessageMetadata, in any) (any, error)
+
 // KafkaContextInitializer function type
 type KafkaContextInitializer func(context.Context) context.Context
 
+// KafkaRebalanceHandler is notified when partitions are assigned to or revoked from a consumer. claims gives the claimed partitions of
+// each topic. Offsets of the session can be committed synchronously with session.Commit(). Returning an error ends the session
+type KafkaRebalanceHandler func(ctx context.Context, session sarama.ConsumerGroupSession, claims map[string][]int32) error
+
+// KafkaConsumerErrorHandler is notified each time a consumer faces a fatal error, before its failure policy is applied
+type KafkaConsumerErrorHandler func(ctx context.Context, consumerID string, err error)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+)
+
 type consumer struct {
 	initialized         bool
 	cluster             *cluster
@@ -33,13 +51,65 @@ type consumer struct {
 	failureProducer     *producer
 	consumptionDelay    *time.Duration
 	consumerGroup       sarama.ConsumerGroup
-	mappers             []KafkaMessageMapper
+	mappers             []KafkaMessageMapperWithMetadata
 	autoCommit          bool
 	handler             KafkaMessageHandler
 	contextInit         KafkaContextInitializer
 	logger              Logger
 	logEventRate        int64
 	initialOffset       int64
+	failurePolicy       string
+	restartBackoff      time.Duration
+	restartMaxBackoff   time.Duration
+	errorHandler        KafkaConsumerErrorHandler
+	onAssigned          KafkaRebalanceHandler
+	onRevoked           KafkaRebalanceHandler
+	retry               *retryPolicy
+	retryable           KafkaRetryableErrorPredicate
+	retryProducer       *producer
+	retryStages         []*consumer
+	parent              *consumer
+	exit                func(code int)
+	cancel              context.CancelFunc
+	stopping            atomic.Bool
+	done                chan struct{}
+	// transactionalProducer, when set, runs each handler invocation in a transaction which also commits the consumed offset
+	transactionalProducerName *string
+	transactionalProducer     *producer
+	isolationLevel            sarama.IsolationLevel
+	// saramaTuning overrides the consumer settings of the cluster
+	saramaTuning *KafkaSaramaConsumerRepresentation
+	// Group membership: rebalance strategies by priority, static group instance id and rack of the consumer
+	rebalanceStrategies []string
+	groupInstanceID     string
+	rackID              string
+	// Concurrent processing of the messages of each claim: messages with the same key are processed by the same worker
+	workers     int
+	maxInFlight int
+	// batchHandler, when set, replaces handler: messages are handled by batches built according to the batch policy
+	batchHandler KafkaBatchHandler
+	batch        batchPolicy
+	// Pause state of the consumer, applied again to the claims of each new session
+	pauseMutex       sync.Mutex
+	paused           bool
+	pausedPartitions map[int32]bool
+	claimed          []int32
+	// resumed is closed (and replaced) when the consumer is resumed, to wake up the claims waiting while their partition is paused
+	resumed chan struct{}
+	// initialTime, when set, positions the claimed partitions without committed offset. configuredReset is applied once to each
+	// partition, offsetReset (requested at runtime) is applied by the next session
+	initialTime     func() time.Time
+	configuredReset *offsetReset
+	resetMutex      sync.Mutex
+	offsetReset     *offsetReset
+	resetCtx        context.Context
+	resetCancel     context.CancelFunc
+	// positioned lists the partitions known to have a committed offset carrying the marker of the configured reset, if any. It is
+	// only used by Setup, which is never called concurrently
+	positioned     map[int32]bool
+	newOffsetStore func() (offsetStore, error)
+	storeMutex     sync.Mutex
+	store          offsetStore
 }
 
 func newConsumer(cluster *cluster, consumerRep KafkaConsumerRepresentation, logger Logger) *consumer {
@@ -54,13 +124,54 @@ func newConsumer(cluster *cluster, consumerRep KafkaConsumerRepresentation, logg
 	groupName = strings.Replace(groupName, "<UUID>", uuid.New().String(), 1)
 
 	var initialOffset = sarama.OffsetOldest
+	var initialTime func() time.Time
 	if consumerRep.InitialOffset != nil {
 		if *consumerRep.InitialOffset == offsetNewestParam {
 			initialOffset = sarama.OffsetNewest
 		} else if *consumerRep.InitialOffset == offsetOldestParam {
 			initialOffset = sarama.OffsetOldest
+		} else {
+			// RFC3339 timestamp or negative duration
+			initialTime, _ = parseOffsetTime(*consumerRep.InitialOffset)
 		}
 	}
+	var resetCtx, resetCancel = context.WithCancel(context.Background())
+
+	var failurePolicy = failurePolicyRestart
+	if consumerRep.FailurePolicy != nil {
+		failurePolicy = *consumerRep.FailurePolicy
+	}
+	var isolationLevel = sarama.ReadUncommitted
+	if consumerRep.IsolationLevel != nil && *consumerRep.IsolationLevel == isolationLevelReadCommitted {
+		isolationLevel = sarama.ReadCommitted
+	}
+	var restartBackoff = defaultRestartBackoff
+	if consumerRep.RestartBackoff != nil {
+		restartBackoff = *consumerRep.RestartBackoff
+	}
+	var restartMaxBackoff = max(restartBackoff, defaultRestartMaxBackoff)
+	if consumerRep.RestartMaxBackoff != nil {
+		restartMaxBackoff = *consumerRep.RestartMaxBackoff
+	}
+	// Group instance id and rack id can reference environment variables, like ${HOSTNAME}
+	var groupInstanceID, rackID string
+	if consumerRep.GroupInstanceID != nil {
+		if groupInstanceID = os.ExpandEnv(*consumerRep.GroupInstanceID); groupInstanceID == "" {
+			logger.Warn(context.Background(), "msg", "Group instance id is empty: static membership disabled", "consumer", *consumerRep.ID,
+				"template", *consumerRep.GroupInstanceID)
+		}
+	}
+	if consumerRep.RackID != nil {
+		rackID = os.ExpandEnv(*consumerRep.RackID)
+	}
+	var workers = 1
+	if consumerRep.Workers != nil {
+		workers = *consumerRep.Workers
+	}
+	var maxInFlight = workers * defaultMaxInFlightPerWorker
+	if consumerRep.MaxInFlight != nil {
+		maxInFlight = *consumerRep.MaxInFlight
+	}
 
 	return &consumer{
 		initialized:         false,
@@ -80,14 +191,48 @@ func newConsumer(cluster *cluster, consumerRep KafkaConsumerRepresentation, logg
 		logger:              logger,
 		logEventRate:        1000,
 		initialOffset:       initialOffset,
+		failurePolicy:       failurePolicy,
+		restartBackoff:      restartBackoff,
+		restartMaxBackoff:   restartMaxBackoff,
+		errorHandler:        func(ctx context.Context, consumerID string, err error) {},
+		retry:               newRetryPolicy(consumerRep.Retry),
+		retryable:           func(err error) bool { return true },
+		exit:                os.Exit,
+
+		transactionalProducerName: consumerRep.TransactionalProducer,
+		isolationLevel:            isolationLevel,
+		saramaTuning:              consumerRep.Sarama,
+		rebalanceStrategies:       consumerRep.RebalanceStrategies,
+		groupInstanceID:           groupInstanceID,
+		rackID:                    rackID,
+		workers:                   workers,
+		maxInFlight:               maxInFlight,
+		batch:                     newBatchPolicy(consumerRep.Batch),
+		pausedPartitions:          map[int32]bool{},
+		resumed:                   make(chan struct{}),
+		initialTime:               initialTime,
+		configuredReset:           newOffsetReset(consumerRep.ResetOffsets),
+		positioned:                map[int32]bool{},
+		resetCtx:                  resetCtx,
+		resetCancel:               resetCancel,
+		newOffsetStore:            cluster.newOffsetStore,
 	}
 }
 
 func (c *consumer) Close() error {
+	var anError error
+	for _, stage := range c.retryStages {
+		if err := stage.Close(); err != nil {
+			anError = err
+		}
+	}
+	if err := c.closeOffsetStore(); err != nil {
+		c.logger.Warn(context.Background(), "msg", "Failed to close Kafka client used to reset offsets", "topic", c.topic, "err", err)
+		anError = err
+	}
 	if !c.initialized || !c.enabled {
-		return nil
+		return anError
 	}
-	var anError error
 	if err := c.consumerGroup.Close(); err != nil {
 		c.logger.Warn(context.Background(), "msg", "Failed to close consumer group", "group", c.consumerGroupName, "err", err)
 		anError = err
@@ -95,6 +240,62 @@ func (c *consumer) Close() error {
 	return anError
 }
 
+// addRetryStage creates the consumer of a retry stage: messages which can't be handled by the previous stage are sent to the
+// stage producer and consumed again by the returned consumer after the given delay
+func (c *consumer) addRetryStage(stageProducer *producer, delay time.Duration) *consumer {
+	var stageRep = KafkaConsumerRepresentation{
+		ID:                new(c.id + "-" + stageProducer.id),
+		Enabled:           new(c.enabled),
+		Topic:             stageProducer.topic,
+		ConsumerGroupName: new(c.consumerGroupName + "-" + stageProducer.id),
+		FailureProducer:   c.failureProducerName,
+		ConsumptionDelay:  &delay,
+		FailurePolicy:     &c.failurePolicy,
+		RestartBackoff:    &c.restartBackoff,
+		RestartMaxBackoff: &c.restartMaxBackoff,
+
+		TransactionalProducer: c.transactionalProducerName,
+		Sarama:                c.saramaTuning,
+		Workers:               &c.workers,
+		MaxInFlight:           &c.maxInFlight,
+	}
+	var stage = newConsumer(c.cluster, stageRep, c.logger)
+	stage.parent = c
+	stage.failureProducer = c.failureProducer
+	stage.transactionalProducer = c.transactionalProducer
+	stage.isolationLevel = c.isolationLevel
+	stage.rebalanceStrategies = c.rebalanceStrategies
+	stage.groupInstanceID = c.groupInstanceID
+	stage.rackID = c.rackID
+	stage.mappers = slices.Clone(c.mappers)
+	stage.autoCommit = c.autoCommit
+	stage.handler = c.handler
+	stage.batchHandler = c.batchHandler
+	stage.batch = c.batch
+	stage.contextInit = c.contextInit
+	stage.onAssigned = c.onAssigned
+	stage.onRevoked = c.onRevoked
+	stage.logEventRate = c.logEventRate
+	stage.errorHandler = c.errorHandler
+	stage.retryable = c.retryable
+
+	var previous = c
+	if len(c.retryStages) > 0 {
+		previous = c.retryStages[len(c.retryStages)-1]
+	}
+	previous.retryProducer = stageProducer
+	c.retryStages = append(c.retryStages, stage)
+	return stage
+}
+
+// withStages applies the given function to the consumer and to the consumers of its retry stages
+func (c *consumer) withStages(apply func(c *consumer)) {
+	apply(c)
+	for _, stage := range c.retryStages {
+		apply(stage)
+	}
+}
+
 func (c *consumer) initialize() error {
 	if c.initialized {
 		return fmt.Errorf("consumer %s already initialized", c.id)
@@ -106,75 +307,217 @@ func (c *consumer) initialize() error {
 		return nil
 	}
 
-	groupConfig := *c.cluster.saramaConfig
-	if c.initialOffset == sarama.OffsetNewest {
-		groupConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
-	} else if c.initialOffset == sarama.OffsetOldest {
-		groupConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
-	}
-
 	// Consumer group
 	var err error
-	if c.consumerGroup, err = c.cluster.getConsumerGroup(c.consumerGroupName, groupConfig); err != nil {
+	if c.consumerGroup, err = c.cluster.getConsumerGroup(c.consumerGroupName, c.groupConfig()); err != nil {
 		return err
 	}
+	for _, stage := range c.retryStages {
+		if err = stage.initialize(); err != nil {
+			return err
+		}
+	}
+	// Producers of the retry stages which have not been initialized by the application
+	for _, stageConsumer := range append([]*consumer{c}, c.retryStages...) {
+		if stageConsumer.retryProducer != nil && !stageConsumer.retryProducer.initialized {
+			if err = stageConsumer.retryProducer.initialize(); err != nil {
+				return err
+			}
+		}
+	}
 	// Done
 	c.initialized = true
 	return nil
 }
 
+// groupConfig returns a copy of the configuration of the cluster completed with the settings of the consumer
+func (c *consumer) groupConfig() sarama.Config {
+	var groupConfig = *c.cluster.saramaConfig
+	if c.initialOffset == sarama.OffsetNewest {
+		groupConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	} else if c.initialOffset == sarama.OffsetOldest {
+		groupConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	groupConfig.Consumer.IsolationLevel = c.isolationLevel
+	applyConsumerTuning(&groupConfig, c.saramaTuning)
+	if len(c.rebalanceStrategies) > 0 {
+		// Each consumer group needs its own instances as some strategies keep a state
+		groupConfig.Consumer.Group.Rebalance.GroupStrategies = nil
+		for _, strategy := range c.rebalanceStrategies {
+			groupConfig.Consumer.Group.Rebalance.GroupStrategies = append(groupConfig.Consumer.Group.Rebalance.GroupStrategies,
+				rebalanceStrategies[strategy]())
+		}
+	}
+	groupConfig.Consumer.Group.InstanceId = c.groupInstanceID
+	groupConfig.RackID = c.rackID
+	return groupConfig
+}
+
 func (c *consumer) SetHandler(handler KafkaMessageHandler) *consumer {
-	c.handler = handler
+	c.withStages(func(c *consumer) { c.handler = handler })
+	return c
+}
+
+// SetBatchHandler sets a handler invoked with batches of messages instead of the message handler. Batches are handled as soon as
+// their size, bytes or linger time threshold is reached
+func (c *consumer) SetBatchHandler(handler KafkaBatchHandler) *consumer {
+	c.withStages(func(c *consumer) { c.batchHandler = handler })
 	return c
 }
 
 func (c *consumer) SetLogEventRate(rate int64) *consumer {
 	if rate > 0 {
-		c.logEventRate = rate
+		c.withStages(func(c *consumer) { c.logEventRate = rate })
 	}
 	return c
 }
 
 func (c *consumer) SetContextInitializer(ctxInitializer KafkaContextInitializer) *consumer {
-	c.contextInit = ctxInitializer
+	c.withStages(func(c *consumer) { c.contextInit = ctxInitializer })
 	return c
 }
 
 func (c *consumer) AddContentMapper(mapper KafkaMessageMapper) *consumer {
-	c.mappers = append(c.mappers, mapper)
+	return c.AddContentMapperWithMetadata(func(ctx context.Context, metadata KafkaMessageMetadata, in any) (any, error) {
+		return mapper(ctx, metadata.GetOffset(), in)
+	})
+}
+
+func (c *consumer) AddContentMapperWithMetadata(mapper KafkaMessageMapperWithMetadata) *consumer {
+	c.withStages(func(c *consumer) { c.mappers = append(c.mappers, mapper) })
 	return c
 }
 
 func (c *consumer) SetAutoCommit(enabled bool) {
-	c.autoCommit = enabled
+	c.withStages(func(c *consumer) { c.autoCommit = enabled })
+}
+
+func (c *consumer) SetErrorHandler(handler KafkaConsumerErrorHandler) *consumer {
+	c.withStages(func(c *consumer) { c.errorHandler = handler })
+	return c
+}
+
+// OnPartitionsAssigned registers a handler called when a new session starts, with the partitions assigned to the consumer, before
+// messages are consumed
+func (c *consumer) OnPartitionsAssigned(handler KafkaRebalanceHandler) *consumer {
+	c.withStages(func(c *consumer) { c.onAssigned = handler })
+	return c
+}
+
+// OnPartitionsRevoked registers a handler called when a session ends, with the partitions the consumer is about to lose, once all
+// messages have been processed
+func (c *consumer) OnPartitionsRevoked(handler KafkaRebalanceHandler) *consumer {
+	c.withStages(func(c *consumer) { c.onRevoked = handler })
+	return c
 }
 
-func (c *consumer) Go() {
+// SetRetryableErrorPredicate overrides the predicate used to know if a handler error can be retried. By default, all errors are retried
+func (c *consumer) SetRetryableErrorPredicate(predicate KafkaRetryableErrorPredicate) *consumer {
+	c.withStages(func(c *consumer) { c.retryable = predicate })
+	return c
+}
+
+// Go starts consuming the topic in a supervised goroutine. Consuming stops when ctx is done or when a fatal error occurs and
+// the failure policy of the consumer is not "restart"
+func (c *consumer) Go(ctx context.Context) {
 	if c.initialized && c.enabled {
+		ctx, c.cancel = context.WithCancel(ctx)
+		c.done = make(chan struct{})
 		go func() {
-			var failureTopic = "none"
-			if c.failureProducerName != nil {
-				failureTopic = *c.failureProducerName
-			}
-			c.logger.Info(context.Background(), "msg", "Just started thread to consume queue", "topic", c.topic, "failure-topic", failureTopic)
-			for {
-				c.consumerGroup.Consume(context.Background(), []string{c.topic}, c)
-				select {
-				case err := <-c.consumerGroup.Errors():
-					c.logger.Error(context.Background(), "msg", "Failure during message processing. Exit", "err", err, "topic", c.topic)
-					os.Exit(1)
-				default:
-				}
-			}
+			defer close(c.done)
+			c.supervise(ctx)
 		}()
 	}
+	for _, stage := range c.retryStages {
+		stage.Go(ctx)
+	}
+}
+
+func (c *consumer) supervise(ctx context.Context) {
+	var failureTopic = "none"
+	if c.failureProducerName != nil {
+		failureTopic = *c.failureProducerName
+	}
+	c.logger.Info(ctx, "msg", "Just started thread to consume queue", "topic", c.topic, "failure-topic", failureTopic, "failure-policy", c.failurePolicy)
+
+	var backoff = c.restartBackoff
+	for ctx.Err() == nil {
+		var err = c.consumerGroup.Consume(ctx, []string{c.topic}, c)
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil {
+			err = c.pendingError()
+		}
+		if err == nil {
+			backoff = c.restartBackoff
+			continue
+		}
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			c.logger.Info(ctx, "msg", "Consumer group closed. Stop consuming", "topic", c.topic)
+			return
+		}
+
+		c.errorHandler(ctx, c.id, err)
+		switch c.failurePolicy {
+		case failurePolicyStop:
+			c.logger.Error(ctx, "msg", "Failure during message processing. Stop consuming", "err", err, "topic", c.topic)
+			return
+		case failurePolicyExit:
+			c.logger.Error(ctx, "msg", "Failure during message processing. Exit", "err", err, "topic", c.topic)
+			c.exit(1)
+			return
+		}
+
+		c.logger.Warn(ctx, "msg", "Failure during message processing. Restart consuming", "err", err, "topic", c.topic, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.restartMaxBackoff)
+	}
+	c.logger.Info(context.Background(), "msg", "Context done. Stop consuming", "topic", c.topic)
+}
+
+// Stop stops fetching new messages and waits until the current handler invocations are finished and the marked offsets are
+// committed. It returns an error if ctx is done before the consumer is stopped
+func (c *consumer) Stop(ctx context.Context) error {
+	for _, stage := range c.retryStages {
+		if err := stage.Stop(ctx); err != nil {
+			return err
+		}
+	}
+	if c.done == nil {
+		return nil
+	}
+	c.stopping.Store(true)
+	c.cancel()
+	select {
+	case <-c.done:
+		c.logger.Info(ctx, "msg", "Consumer stopped", "topic", c.topic)
+		return nil
+	case <-ctx.Done():
+		c.logger.Warn(ctx, "msg", "Consumer not stopped in time", "topic", c.topic, "err", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// pendingError returns the first error reported by the consumer group, if any
+func (c *consumer) pendingError() error {
+	select {
+	case err := <-c.consumerGroup.Errors():
+		return err
+	default:
+		return nil
+	}
 }
 
 func (c *consumer) applyMappers(ctx context.Context, kafkaMsg *sarama.ConsumerMessage) (any, error) {
 	var content any = kafkaMsg.Value
+	var metadata KafkaMessageMetadata = &consumedMessage{msg: kafkaMsg, consumer: c}
 	for idx, mapper := range c.mappers {
 		var err error
-		if content, err = mapper(ctx, kafkaMsg.Offset, content); err != nil {
+		if content, err = mapper(ctx, metadata, content); err != nil {
 			logMsg := fmt.Sprintf("Mapper #%d failed to map content", idx+1)
 			c.logger.Error(ctx, "msg", logMsg, "err", err, "topic", c.topic, "offset", kafkaMsg.Offset,
 				"partition", kafkaMsg.Partition, "contentLength", len(kafkaMsg.Value))
@@ -185,56 +528,223 @@ func (c *consumer) applyMappers(ctx context.Context, kafkaMsg *sarama.ConsumerMe
 }
 
 func (c *consumer) Setup(session sarama.ConsumerGroupSession) error {
+	var claims = session.Claims()
+	c.claimPartitions(claims[c.topic])
+	if err := c.seekClaims(session, claims[c.topic]); err != nil {
+		return err
+	}
+	if c.onAssigned != nil {
+		return c.onAssigned(c.contextInit(session.Context()), session, claims)
+	}
 	return nil
 }
 
 func (c *consumer) Cleanup(session sarama.ConsumerGroupSession) error {
-	return nil
+	var err error
+	if c.onRevoked != nil {
+		// The session context is already canceled when Cleanup is called: the callback gets its own context, bounded by the time
+		// given to the members of the group to leave the rebalance, tuning of the consumer included
+		var ctx, cancel = context.WithTimeout(c.contextInit(context.Background()), c.groupConfig().Consumer.Group.Rebalance.Timeout)
+		defer cancel()
+		err = c.onRevoked(ctx, session, session.Claims())
+	}
+	if c.stopping.Load() {
+		// Last session of the consumer: synchronously flush the marked offsets
+		session.Commit()
+	}
+	return err
 }
 
 // This function is called in several goroutines ==> needs to be thread safe
 func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for kafkaMsg := range claim.Messages() {
+	session, release := c.withResetContext(session)
+	defer release()
+	c.applyPause(claim)
+	if c.batchHandler != nil {
+		return c.consumeClaimInBatches(session, claim)
+	}
+	if c.workers > 1 {
+		return c.consumeClaimConcurrently(session, claim)
+	}
+	var messages = claim.Messages()
+	for {
+		var kafkaMsg *sarama.ConsumerMessage
+		select {
+		case <-session.Context().Done():
+			// Session is ending (rebalance or stop): do not process buffered messages
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			kafkaMsg = msg
+		}
+
 		ctx := c.contextInit(context.Background())
+		if !c.waitWhilePaused(session.Context(), kafkaMsg.Partition) || !c.delayConsumption(ctx, session, kafkaMsg) {
+			return nil
+		}
+		if err := c.processMessage(ctx, session, claim, kafkaMsg, nil); err != nil {
+			if errors.Is(err, errSessionEnded) {
+				return nil
+			}
+			return err
+		}
+	}
+}
 
-		if c.consumptionDelay != nil {
-			sinceMessageProduction := time.Since(kafkaMsg.Timestamp)
-			if sinceMessageProduction < *c.consumptionDelay {
-				pauseDuration := *c.consumptionDelay - sinceMessageProduction
-				c.logger.Info(ctx, "msg", "pause consumption because of consumption delay", "pauseDuration", pauseDuration, "consumptionDelay", *c.consumptionDelay, "consumerGroupName", c.consumerGroupName)
-				time.Sleep(pauseDuration)
+// consumeClaimConcurrently dispatches the messages of the claim to a pool of workers. Once the session ends or a message can't be
+// processed, it waits for the messages in flight before returning
+func (c *consumer) consumeClaimConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var pool = newWorkerPool(c, session, claim)
+	var messages = claim.Messages()
+	for {
+		var kafkaMsg *sarama.ConsumerMessage
+		select {
+		case <-pool.ctx.Done():
+			return pool.close()
+		case msg, ok := <-messages:
+			if !ok {
+				return pool.close()
 			}
+			kafkaMsg = msg
 		}
 
-		var content, err = c.applyMappers(ctx, kafkaMsg)
-		var msg = &consumedMessage{
-			msg:      kafkaMsg,
-			content:  content,
-			consumer: c,
-			session:  session,
-			abort:    false,
+		ctx := c.contextInit(context.Background())
+		if !c.delayConsumption(ctx, session, kafkaMsg) || !pool.dispatch(ctx, kafkaMsg) {
+			return pool.close()
 		}
+	}
+}
+
+// delayConsumption waits until the consumption delay of the consumer is elapsed since the message was produced. The partition of the
+// message is paused meanwhile, without blocking the session: it returns false if the session ends before the delay is elapsed
+func (c *consumer) delayConsumption(ctx context.Context, session sarama.ConsumerGroupSession, kafkaMsg *sarama.ConsumerMessage) bool {
+	if c.consumptionDelay == nil {
+		return true
+	}
+	sinceMessageProduction := time.Since(kafkaMsg.Timestamp)
+	if sinceMessageProduction >= *c.consumptionDelay {
+		return true
+	}
+	pauseDuration := *c.consumptionDelay - sinceMessageProduction
+	c.logger.Info(ctx, "msg", "pause consumption because of consumption delay", "pauseDuration", pauseDuration, "consumptionDelay", *c.consumptionDelay, "consumerGroupName", c.consumerGroupName)
+
+	c.consumerGroup.Pause(map[string][]int32{c.topic: {kafkaMsg.Partition}})
+	defer c.resumeAfterDelay(kafkaMsg.Partition)
+	var timer = time.NewTimer(pauseDuration)
+	defer timer.Stop()
+	select {
+	case <-session.Context().Done():
+		// Session is ending (rebalance or stop): the message will be consumed again by the next session
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// processMessage maps and handles a consumed message, then commits it if auto-commit is enabled. When offsets is not nil, the message
+// is processed concurrently with other messages of the claim and its offset is marked through offsets. The returned error ends the
+// claim: errSessionEnded when the session ended before the message could be handled
+func (c *consumer) processMessage(ctx context.Context, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, kafkaMsg *sarama.ConsumerMessage,
+	offsets *claimOffsets) error {
+	var content, err = c.applyMappers(ctx, kafkaMsg)
+	var msg = &consumedMessage{
+		msg:              kafkaMsg,
+		content:          content,
+		consumer:         c,
+		session:          session,
+		offsets:          offsets,
+		abort:            false,
+		previousAttempts: retriedAttempts(kafkaMsg),
+	}
+	if err != nil {
+		msg.SendToFailureTopicWithError(err)
+	} else {
+		err = c.handleMessage(ctx, session, msg)
 		if err != nil {
-			msg.SendToFailureTopic()
-		} else {
-			err = c.handler(ctx, msg)
-			if err != nil {
-				c.logger.Error(ctx, "msg", "Failed to handle event", "err", err.Error(), "topic", claim.Topic())
-				if msg.abort {
+			c.logger.Error(ctx, "msg", "Failed to handle event", "err", err.Error(), "topic", claim.Topic(), "attempts", msg.attempt)
+			if msg.abort {
+				return err
+			}
+			if session.Context().Err() != nil {
+				// Session ended while retrying: the message will be consumed again by the next session
+				return errSessionEnded
+			}
+			if c.routesFailures() {
+				// In-process retries are exhausted
+				if err = c.routeFailure(ctx, session, msg, err); err != nil {
 					return err
 				}
 			}
-			if kafkaMsg.Offset%c.logEventRate == 0 {
-				logMsg := fmt.Sprintf("Messages from %d to %d offset are processed", kafkaMsg.Offset-c.logEventRate, kafkaMsg.Offset)
-				c.logger.Info(ctx, "msg", logMsg, "topic", c.topic, "partition", kafkaMsg.Partition, "topic", claim.Topic())
-			}
 		}
-
-		// Commit event
-		if c.autoCommit {
-			session.MarkMessage(kafkaMsg, "")
+		if kafkaMsg.Offset%c.logEventRate == 0 {
+			logMsg := fmt.Sprintf("Messages from %d to %d offset are processed", kafkaMsg.Offset-c.logEventRate, kafkaMsg.Offset)
+			c.logger.Info(ctx, "msg", logMsg, "topic", c.topic, "partition", kafkaMsg.Partition, "topic", claim.Topic())
 		}
 	}
 
+	// Commit event
+	if c.autoCommit {
+		msg.Commit()
+	}
 	return nil
 }
+
+// invokeHandler invokes the handler. With a transactional producer, the messages sent by the handler through this producer and the
+// offset of the consumed message are committed in the same transaction, which is aborted if the handler fails
+func (c *consumer) invokeHandler(ctx context.Context, msg *consumedMessage) error {
+	if c.transactionalProducer == nil {
+		return c.handler(ctx, msg)
+	}
+	return c.transactionalProducer.runInTransaction(func() error {
+		return c.handler(ctx, msg)
+	}, msg.msg, c.consumerGroupName)
+}
+
+// routeFailure sends a message which can't be handled to the next retry stage or to the failure topic. Sending is retried according to
+// the retry policy of the consumer, so that a producer which is temporarily unavailable does not end the session
+func (c *consumer) routeFailure(ctx context.Context, session sarama.ConsumerGroupSession, msg *consumedMessage, cause error) error {
+	for attempt := 1; ; attempt++ {
+		var err = msg.routeFailure(cause)
+		if err == nil {
+			return nil
+		}
+		if c.retry == nil || attempt >= c.retry.maxAttempts {
+			c.logger.Error(ctx, "msg", "Failed to route event to retry stage or failure topic", "err", err.Error(), "topic", c.topic)
+			return err
+		}
+		var backoff = c.retry.backoff(attempt)
+		c.logger.Warn(ctx, "msg", "Failed to route event to retry stage or failure topic. Retry", "err", err.Error(), "topic", c.topic,
+			"attempt", attempt, "backoff", backoff)
+		select {
+		case <-session.Context().Done():
+			// The message will be consumed again by the next session
+			return errSessionEnded
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// routesFailures tells if the messages which can't be handled have to be automatically sent to a retry stage or to the failure topic
+func (c *consumer) routesFailures() bool {
+	return c.retry != nil || c.retryProducer != nil || c.parent != nil
+}
+
+// handleMessage invokes the handler and retries it according to the retry policy of the consumer. It returns the error of the last attempt
+func (c *consumer) handleMessage(ctx context.Context, session sarama.ConsumerGroupSession, msg *consumedMessage) error {
+	for {
+		msg.attempt++
+		var err = c.invokeHandler(ctx, msg)
+		if err == nil || msg.abort || c.retry == nil || msg.attempt >= c.retry.maxAttempts || !c.retryable(err) {
+			return err
+		}
+		var backoff = c.retry.backoff(msg.attempt)
+		c.logger.Warn(ctx, "msg", "Failed to handle event. Retry", "err", err.Error(), "topic", c.topic, "attempt", msg.attempt, "backoff", backoff)
+		select {
+		case <-session.Context().Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -3,6 +3,7 @@ package kafkauniverse
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strconv"
 	"testing"
 	"time"
@@ -119,10 +120,25 @@ func TestConsumerSimpleFunctions(t *testing.T) {
 			assert.Nil(t, err)
 			assert.Equal(t, sarama.OffsetOldest, consumer.initialOffset)
 		})
+		t.Run("Retry stage producers", func(t *testing.T) {
+			var consumer = newConsumer(cluster, consumerConf, logger)
+			var stageProducer1 = &producer{id: "retry-1", topic: new("topic-retry-1")}
+			var stageProducer2 = &producer{initialized: true, id: "retry-2", topic: new("topic-retry-2")}
+			consumer.addRetryStage(stageProducer1, time.Second)
+			consumer.addRetryStage(stageProducer2, time.Minute)
+			consumer.enabled = true // the consumer group of a disabled cluster is a no-op
+			assert.Nil(t, consumer.initialize())
+			assert.True(t, stageProducer1.initialized)
+			assert.NotNil(t, stageProducer1.producer)
+			// Producers already initialized by the application are kept
+			assert.Nil(t, stageProducer2.producer)
+		})
 	})
 	t.Run("Setup", func(t *testing.T) {
 		var consumer = newConsumer(cluster, consumerConf, logger)
+		mockConsumerGroupSession.EXPECT().Claims().Return(map[string][]int32{"topic": {0, 1}})
 		assert.Nil(t, consumer.Setup(mockConsumerGroupSession))
+		assert.Equal(t, []int32{0, 1}, consumer.claimed)
 	})
 	t.Run("Cleanup", func(t *testing.T) {
 		var consumer = newConsumer(cluster, consumerConf, logger)
@@ -204,7 +220,9 @@ func TestOffsetNewestConsumerSimpleFunctions(t *testing.T) {
 	})
 	t.Run("Setup", func(t *testing.T) {
 		var consumer = newConsumer(cluster, consumerConf, logger)
+		mockConsumerGroupSession.EXPECT().Claims().Return(map[string][]int32{"topic": {0, 1}})
 		assert.Nil(t, consumer.Setup(mockConsumerGroupSession))
+		assert.Equal(t, []int32{0, 1}, consumer.claimed)
 	})
 	t.Run("Cleanup", func(t *testing.T) {
 		var consumer = newConsumer(cluster, consumerConf, logger)
@@ -224,6 +242,59 @@ func fillMessageChannel(messages chan *sarama.ConsumerMessage, values ...string)
 	}()
 }
 
+func TestConsumerGroupConfig(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	t.Setenv("KAFKA_TEST_HOSTNAME", "pod-1")
+	t.Setenv("KAFKA_TEST_ZONE", "zone-a")
+
+	t.Run("Defaults", func(t *testing.T) {
+		var groupConfig = newConsumer(cluster, createOffsetNewestConsumerConfiguration(), logger).groupConfig()
+		assert.Equal(t, sarama.OffsetNewest, groupConfig.Consumer.Offsets.Initial)
+		assert.Equal(t, "", groupConfig.Consumer.Group.InstanceId)
+		assert.Equal(t, "", groupConfig.RackID)
+		assert.Len(t, groupConfig.Consumer.Group.Rebalance.GroupStrategies, 1)
+		// The configuration of the cluster is not modified
+		assert.Equal(t, sarama.OffsetOldest, cluster.saramaConfig.Consumer.Offsets.Initial)
+	})
+	t.Run("Group membership", func(t *testing.T) {
+		var consumerConf = createDefaultConsumerConfiguration()
+		consumerConf.RebalanceStrategies = []string{"sticky", "round-robin", "range"}
+		consumerConf.GroupInstanceID = new("${KAFKA_TEST_HOSTNAME}-consumer")
+		consumerConf.RackID = new("$KAFKA_TEST_ZONE")
+		var aConsumer = newConsumer(cluster, consumerConf, logger)
+		var groupConfig = aConsumer.groupConfig()
+		var names []string
+		for _, strategy := range groupConfig.Consumer.Group.Rebalance.GroupStrategies {
+			names = append(names, strategy.Name())
+		}
+		assert.Equal(t, []string{sarama.StickyBalanceStrategyName, sarama.RoundRobinBalanceStrategyName, sarama.RangeBalanceStrategyName}, names)
+		assert.Equal(t, "pod-1-consumer", groupConfig.Consumer.Group.InstanceId)
+		assert.Equal(t, "zone-a", groupConfig.RackID)
+		// Each consumer group gets its own strategy instances
+		assert.NotSame(t, groupConfig.Consumer.Group.Rebalance.GroupStrategies[0], aConsumer.groupConfig().Consumer.Group.Rebalance.GroupStrategies[0])
+	})
+	t.Run("Empty group instance id", func(t *testing.T) {
+		var consumerConf = createDefaultConsumerConfiguration()
+		consumerConf.GroupInstanceID = new("${KAFKA_TEST_UNDEFINED}")
+		logger.EXPECT().Warn(gomock.Any(), gomock.Any())
+		var groupConfig = newConsumer(cluster, consumerConf, logger).groupConfig()
+		assert.Equal(t, "", groupConfig.Consumer.Group.InstanceId)
+	})
+	t.Run("Tuning", func(t *testing.T) {
+		var consumerConf = createDefaultConsumerConfiguration()
+		consumerConf.Sarama = &KafkaSaramaConsumerRepresentation{SessionTimeout: new(time.Minute)}
+		var groupConfig = newConsumer(cluster, consumerConf, logger).groupConfig()
+		assert.Equal(t, time.Minute, groupConfig.Consumer.Group.Session.Timeout)
+	})
+}
+
 func TestConsumeClaim(t *testing.T) {
 	var mockCtrl = gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -242,6 +313,7 @@ func TestConsumeClaim(t *testing.T) {
 
 	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
 	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
 
 	t.Run("Empty messages", func(t *testing.T) {
 		var messages = make(chan *sarama.ConsumerMessage)
@@ -290,12 +362,58 @@ func TestConsumeClaim(t *testing.T) {
 	})
 }
 
+func TestConsumeClaimWithMetadataMapper(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var messages = make(chan *sarama.ConsumerMessage, 1)
+	messages <- &sarama.ConsumerMessage{
+		Timestamp: time.Now(),
+		Key:       []byte("a-key"),
+		Value:     []byte("345"),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("schema-version"), Value: []byte("2")}},
+	}
+	close(messages)
+
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic")
+	mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+	consumer.AddContentMapper(func(ctx context.Context, messageOffset int64, in any) (any, error) {
+		return strconv.Atoi(string(in.([]byte)))
+	})
+	consumer.AddContentMapperWithMetadata(func(ctx context.Context, metadata KafkaMessageMetadata, in any) (any, error) {
+		var version, _ = metadata.GetHeader("schema-version")
+		return fmt.Sprintf("%s/%s/%d", metadata.GetKey(), version, in.(int)), nil
+	})
+	consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+		assert.Equal(t, "a-key/2/345", msg.GetContent())
+		assert.Equal(t, []byte("345"), msg.GetRawValue())
+		return nil
+	})
+
+	var err = consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim)
+	assert.Nil(t, err)
+}
+
 func TestConsumeClaimWithDelay(t *testing.T) {
 	var mockCtrl = gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
 	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var mockConsumerGroup = mock.NewConsumerGroup(mockCtrl)
 
 	var logger = mock.NewLogger(mockCtrl)
 	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
@@ -305,19 +423,586 @@ func TestConsumeClaimWithDelay(t *testing.T) {
 		saramaConfig: createSaramaConfig(),
 	}
 
-	delay, _ := time.ParseDuration("1ms")
+	var delay = 20 * time.Millisecond
 	var consumerConf = createDefaultConsumerConfiguration()
 	consumerConf.ConsumptionDelay = &delay
 	var consumer = newConsumer(cluster, consumerConf, logger)
-	consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+	consumer.consumerGroup = mockConsumerGroup
+	var partitions = map[string][]int32{"topic": {2}}
+
+	t.Run("Partition paused until delay is elapsed", func(t *testing.T) {
+		var handledAt time.Time
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			handledAt = time.Now()
+			return nil
+		})
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		var producedAt = time.Now()
+		messages <- &sarama.ConsumerMessage{Topic: "topic", Partition: 2, Timestamp: producedAt, Value: []byte("message")}
+		close(messages)
+
+		mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroupClaim.EXPECT().Topic().Return("topic")
+		gomock.InOrder(
+			mockConsumerGroup.EXPECT().Pause(partitions),
+			mockConsumerGroup.EXPECT().Resume(partitions),
+			mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), ""),
+		)
+		var err = consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim)
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, handledAt.Sub(producedAt), delay)
+	})
+	t.Run("Session ends during delay", func(t *testing.T) {
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			assert.Fail(t, "message should not be handled")
+			return nil
+		})
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		messages <- &sarama.ConsumerMessage{Topic: "topic", Partition: 2, Timestamp: time.Now().Add(time.Hour), Value: []byte("message")}
+
+		var ctx, cancel = context.WithCancel(context.TODO())
+		var session = mock.NewConsumerGroupSession(mockCtrl)
+		session.EXPECT().Context().Return(ctx).AnyTimes()
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroup.EXPECT().Pause(partitions).Do(func(map[string][]int32) { cancel() })
+		mockConsumerGroup.EXPECT().Resume(partitions)
+
+		var err = consumer.ConsumeClaim(session, mockConsumerGroupClaim)
+		assert.Nil(t, err)
+	})
+}
+
+func TestConsumerGo(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockConsumerGroup = mock.NewConsumerGroup(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var anError = errors.New("an error")
+
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var createStartableConsumer = func(policy string) *consumer {
+		var consumerConf = createDefaultConsumerConfiguration()
+		consumerConf.FailurePolicy = &policy
+		consumerConf.RestartBackoff = new(time.Millisecond)
+		var consumer = newConsumer(cluster, consumerConf, logger)
+		consumer.initialized = true
+		consumer.enabled = true
+		consumer.consumerGroup = mockConsumerGroup
+		return consumer
+	}
+	var noError = make(chan error)
+
+	t.Run("Not initialized", func(t *testing.T) {
+		var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		consumer.Go(context.TODO())
+		assert.Nil(t, consumer.done)
+	})
+	t.Run("Stop policy", func(t *testing.T) {
+		var consumer = createStartableConsumer(failurePolicyStop)
+		var reported error
+		consumer.SetErrorHandler(func(ctx context.Context, consumerID string, err error) {
+			assert.Equal(t, "id-consumer", consumerID)
+			reported = err
+		})
+		mockConsumerGroup.EXPECT().Consume(gomock.Any(), []string{"topic"}, consumer).Return(anError)
+
+		consumer.Go(context.TODO())
+		<-consumer.done
+		assert.Equal(t, anError, reported)
+	})
+	t.Run("Exit policy", func(t *testing.T) {
+		var consumer = createStartableConsumer(failurePolicyExit)
+		var exitCode = 0
+		consumer.exit = func(code int) { exitCode = code }
+		var errorsChan = make(chan error, 1)
+		errorsChan <- anError
+		mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).Return(nil)
+		mockConsumerGroup.EXPECT().Errors().Return(errorsChan)
+
+		consumer.Go(context.TODO())
+		<-consumer.done
+		assert.Equal(t, 1, exitCode)
+	})
+	t.Run("Restart policy", func(t *testing.T) {
+		var consumer = createStartableConsumer(failurePolicyRestart)
+		var ctx, cancel = context.WithCancel(context.TODO())
+		var failures = 0
+		consumer.SetErrorHandler(func(ctx context.Context, consumerID string, err error) {
+			failures++
+		})
+		gomock.InOrder(
+			mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).Return(anError).Times(2),
+			mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).Return(nil),
+			mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).DoAndReturn(
+				func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+					cancel()
+					return nil
+				}),
+		)
+		mockConsumerGroup.EXPECT().Errors().Return(noError)
+
+		consumer.Go(ctx)
+		<-consumer.done
+		assert.Equal(t, 2, failures)
+	})
+	t.Run("Consumer group closed", func(t *testing.T) {
+		var consumer = createStartableConsumer(failurePolicyExit)
+		consumer.exit = func(code int) { assert.Fail(t, "should not exit") }
+		mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).Return(sarama.ErrClosedConsumerGroup)
+
+		consumer.Go(context.TODO())
+		<-consumer.done
+	})
+}
+
+func TestRebalanceHandlers(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	type ctxKey struct{}
+	var ctx = context.TODO()
+	var claims = map[string][]int32{"topic": {0, 2}}
+	var anError = errors.New("an error")
+
+	var consumerRep = createDefaultConsumerConfiguration()
+	consumerRep.Sarama = &KafkaSaramaConsumerRepresentation{RebalanceTimeout: new(42 * time.Second)}
+	var aConsumer = newConsumer(cluster, consumerRep, logger)
+	aConsumer.SetContextInitializer(func(ctx context.Context) context.Context { return context.WithValue(ctx, ctxKey{}, "initialized") })
+	var assigned, revoked map[string][]int32
+	aConsumer.OnPartitionsAssigned(func(ctx context.Context, session sarama.ConsumerGroupSession, claims map[string][]int32) error {
+		assert.Equal(t, "initialized", ctx.Value(ctxKey{}))
+		assert.Equal(t, mockConsumerGroupSession, session)
+		assigned = claims
+		return nil
+	})
+	aConsumer.OnPartitionsRevoked(func(ctx context.Context, session sarama.ConsumerGroupSession, claims map[string][]int32) error {
+		assert.Equal(t, "initialized", ctx.Value(ctxKey{}))
+		assert.Nil(t, ctx.Err())
+		// The context is bounded by the rebalance timeout of the consumer
+		var deadline, ok = ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(42*time.Second), deadline, 5*time.Second)
+		// Offsets can be committed before the partitions are lost
+		session.Commit()
+		revoked = claims
 		return nil
 	})
 
-	var messages = make(chan *sarama.ConsumerMessage)
-	fillMessageChannel(messages, "message")
-	mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
-	mockConsumerGroupClaim.EXPECT().Topic().Return("topic")
-	mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
-	var err = consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim)
-	assert.Nil(t, err)
+	t.Run("Setup", func(t *testing.T) {
+		mockConsumerGroupSession.EXPECT().Context().Return(ctx)
+		mockConsumerGroupSession.EXPECT().Claims().Return(claims)
+		assert.Nil(t, aConsumer.Setup(mockConsumerGroupSession))
+		assert.Equal(t, claims, assigned)
+	})
+	t.Run("Cleanup", func(t *testing.T) {
+		// Sarama cancels the context of the session before calling Cleanup: it must not be given to the callback
+		mockConsumerGroupSession.EXPECT().Claims().Return(claims)
+		mockConsumerGroupSession.EXPECT().Commit()
+		assert.Nil(t, aConsumer.Cleanup(mockConsumerGroupSession))
+		assert.Equal(t, claims, revoked)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		var failing = func(context.Context, sarama.ConsumerGroupSession, map[string][]int32) error { return anError }
+		aConsumer.OnPartitionsAssigned(failing).OnPartitionsRevoked(failing)
+		aConsumer.stopping.Store(true)
+		mockConsumerGroupSession.EXPECT().Context().Return(ctx)
+		mockConsumerGroupSession.EXPECT().Claims().Return(claims).Times(2)
+		assert.Equal(t, anError, aConsumer.Setup(mockConsumerGroupSession))
+		// Marked offsets are still committed when the consumer stops
+		mockConsumerGroupSession.EXPECT().Commit()
+		assert.Equal(t, anError, aConsumer.Cleanup(mockConsumerGroupSession))
+	})
+}
+
+func TestConsumerStop(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockConsumerGroup = mock.NewConsumerGroup(mockCtrl)
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var createStartedConsumer = func(consume func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error) *consumer {
+		var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		consumer.initialized = true
+		consumer.enabled = true
+		consumer.consumerGroup = mockConsumerGroup
+		var consuming = make(chan struct{})
+		mockConsumerGroup.EXPECT().Consume(gomock.Any(), gomock.Any(), consumer).DoAndReturn(
+			func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+				close(consuming)
+				return consume(ctx, topics, handler)
+			})
+		consumer.Go(context.TODO())
+		<-consuming
+		return consumer
+	}
+
+	t.Run("Not started", func(t *testing.T) {
+		var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		assert.Nil(t, consumer.Stop(context.TODO()))
+	})
+	t.Run("Success", func(t *testing.T) {
+		var consumer = createStartedConsumer(func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+			<-ctx.Done()
+			return nil
+		})
+		assert.Nil(t, consumer.Stop(context.TODO()))
+		assert.True(t, consumer.stopping.Load())
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		var release = make(chan struct{})
+		var consumer = createStartedConsumer(func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+			<-release
+			return nil
+		})
+		var ctx, cancel = context.WithTimeout(context.TODO(), time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, consumer.Stop(ctx))
+		close(release)
+		<-consumer.done
+	})
+	t.Run("Cleanup commits when stopping", func(t *testing.T) {
+		var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		consumer.stopping.Store(true)
+		mockConsumerGroupSession.EXPECT().Commit()
+		assert.Nil(t, consumer.Cleanup(mockConsumerGroupSession))
+	})
+	t.Run("ConsumeClaim ends with session", func(t *testing.T) {
+		var consumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			assert.Fail(t, "message should not be handled")
+			return nil
+		})
+		var ctx, cancel = context.WithCancel(context.TODO())
+		cancel()
+		mockConsumerGroupSession.EXPECT().Context().Return(ctx)
+		mockConsumerGroupClaim.EXPECT().Messages().Return(make(chan *sarama.ConsumerMessage))
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+}
+
+func TestConsumeClaimWithRetry(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var handlerError = errors.New("error from handler")
+
+	var consumerConf = createDefaultConsumerConfiguration()
+	consumerConf.FailureProducer = nil
+	consumerConf.Retry = &KafkaRetryRepresentation{
+		MaxAttempts:    new(3),
+		InitialBackoff: new(time.Millisecond),
+	}
+	var consumer = newConsumer(cluster, consumerConf, logger)
+
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+
+	t.Run("Success after retries", func(t *testing.T) {
+		var attempts []int
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			attempts = append(attempts, msg.GetAttempt())
+			if msg.GetAttempt() < 3 {
+				return handlerError
+			}
+			return nil
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+	})
+	t.Run("Retries exhausted", func(t *testing.T) {
+		var calls = 0
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			calls++
+			return handlerError
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, 3, calls)
+	})
+	t.Run("Error is not retryable", func(t *testing.T) {
+		var calls = 0
+		consumer.SetRetryableErrorPredicate(func(err error) bool { return false })
+		defer consumer.SetRetryableErrorPredicate(func(err error) bool { return true })
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			calls++
+			return handlerError
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("Failure topic not initialized", func(t *testing.T) {
+		consumer.failureProducerName = new("failure-producer")
+		defer func() { consumer.failureProducerName = nil }()
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			return handlerError
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+
+		assert.NotNil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Failure topic temporarily unavailable", func(t *testing.T) {
+		var mockFailureProducer = mock.NewSyncProducer(mockCtrl)
+		consumer.failureProducerName = new("failure-producer")
+		consumer.failureProducer = &producer{initialized: true, enabled: true, id: "failure-producer", topic: new("failure-topic"), producer: mockFailureProducer}
+		defer func() { consumer.failureProducerName, consumer.failureProducer = nil, nil }()
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			return handlerError
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		gomock.InOrder(
+			mockFailureProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), errors.New("not enough replicas")),
+			mockFailureProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), nil),
+		)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+}
+
+func TestConsumeClaimWithRetryStages(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var mockStageProducer1 = mock.NewSyncProducer(mockCtrl)
+	var mockStageProducer2 = mock.NewSyncProducer(mockCtrl)
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var handlerError = errors.New("error from handler")
+
+	var newStageProducer = func(id string, syncProducer sarama.SyncProducer) *producer {
+		return &producer{initialized: true, enabled: true, id: id, topic: new("topic-" + id), producer: syncProducer}
+	}
+	var consumerConf = createDefaultConsumerConfiguration()
+	consumerConf.FailureProducer = nil
+	var consumer = newConsumer(cluster, consumerConf, logger)
+	var stage1 = consumer.addRetryStage(newStageProducer("retry-30s", mockStageProducer1), time.Millisecond)
+	var stage2 = consumer.addRetryStage(newStageProducer("retry-5m", mockStageProducer2), 2*time.Millisecond)
+	var mockConsumerGroup = mock.NewConsumerGroup(mockCtrl)
+	mockConsumerGroup.EXPECT().Pause(gomock.Any()).AnyTimes()
+	mockConsumerGroup.EXPECT().Resume(gomock.Any()).AnyTimes()
+	stage1.consumerGroup = mockConsumerGroup
+	stage2.consumerGroup = mockConsumerGroup
+
+	var findHeader = func(msg *sarama.ProducerMessage, key string) string {
+		for _, header := range msg.Headers {
+			if string(header.Key) == key {
+				return string(header.Value)
+			}
+		}
+		return ""
+	}
+
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+
+	t.Run("Stages configuration", func(t *testing.T) {
+		assert.Len(t, consumer.retryStages, 2)
+		assert.Equal(t, "topic-retry-30s", stage1.topic)
+		assert.Equal(t, time.Millisecond, *stage1.consumptionDelay)
+		assert.Equal(t, "consumer-group-retry-30s", stage1.consumerGroupName)
+		assert.Equal(t, "retry-30s", consumer.retryProducer.id)
+		assert.Equal(t, "retry-5m", stage1.retryProducer.id)
+		assert.Nil(t, stage2.retryProducer)
+		assert.Equal(t, consumer, stage2.parent)
+	})
+	t.Run("Handler is shared by the stages", func(t *testing.T) {
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			return handlerError
+		})
+		assert.NotNil(t, stage2.handler(context.TODO(), nil))
+	})
+	t.Run("Failure in main topic is sent to first stage", func(t *testing.T) {
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		messages <- &sarama.ConsumerMessage{
+			Topic: "topic", Partition: 3, Offset: 12, Key: []byte("key"), Value: []byte("value"),
+			Headers: []*sarama.RecordHeader{{Key: []byte("correlation"), Value: []byte("abc")}},
+		}
+		close(messages)
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockStageProducer1.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			assert.Equal(t, "topic-retry-30s", msg.Topic)
+			assert.Equal(t, sarama.ByteEncoder("key"), msg.Key)
+			assert.Equal(t, "abc", findHeader(msg, "correlation"))
+			assert.Equal(t, "topic", findHeader(msg, HeaderOriginalTopic))
+			assert.Equal(t, "3", findHeader(msg, HeaderOriginalPartition))
+			assert.Equal(t, "12", findHeader(msg, HeaderOriginalOffset))
+			assert.Equal(t, "1", findHeader(msg, HeaderRetryAttempt))
+			assert.Equal(t, handlerError.Error(), findHeader(msg, HeaderExceptionMessage))
+			return 0, 0, nil
+		})
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Failure in a stage is sent to the next stage", func(t *testing.T) {
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		messages <- &sarama.ConsumerMessage{
+			Topic: "topic-retry-30s", Partition: 0, Offset: 1, Value: []byte("value"),
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte(HeaderOriginalTopic), Value: []byte("topic")},
+				{Key: []byte(HeaderOriginalPartition), Value: []byte("3")},
+				{Key: []byte(HeaderOriginalOffset), Value: []byte("12")},
+				{Key: []byte(HeaderRetryAttempt), Value: []byte("1")},
+				{Key: []byte(HeaderExceptionMessage), Value: []byte("previous error")},
+			},
+		}
+		close(messages)
+		stage1.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			assert.Equal(t, 2, msg.GetAttempt())
+			return handlerError
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockStageProducer2.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			assert.Equal(t, "topic-retry-5m", msg.Topic)
+			assert.Nil(t, msg.Key)
+			assert.Len(t, msg.Headers, 7)
+			assert.Equal(t, "topic", findHeader(msg, HeaderOriginalTopic))
+			assert.Equal(t, "3", findHeader(msg, HeaderOriginalPartition))
+			assert.Equal(t, "12", findHeader(msg, HeaderOriginalOffset))
+			assert.Equal(t, "2", findHeader(msg, HeaderRetryAttempt))
+			assert.Equal(t, handlerError.Error(), findHeader(msg, HeaderExceptionMessage))
+			return 0, 0, nil
+		})
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, stage1.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Failure in last stage without failure topic", func(t *testing.T) {
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, stage2.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+}
+
+func TestConsumeClaimWithTransaction(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var handlerError = errors.New("error from handler")
+
+	var consumerConf = createDefaultConsumerConfiguration()
+	consumerConf.FailureProducer = nil
+	consumerConf.Retry = &KafkaRetryRepresentation{
+		MaxAttempts:    new(2),
+		InitialBackoff: new(time.Millisecond),
+	}
+	var consumer = newConsumer(cluster, consumerConf, logger)
+	consumer.transactionalProducer = &producer{initialized: true, enabled: true, id: "txn-producer", topic: new("output"),
+		transactionalID: new("transactional-id"), producer: mockProducer, logger: logger}
+
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+
+	t.Run("Commit", func(t *testing.T) {
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			var _, err = msg.GetTransactionalProducer().SendMessage(ctx, Message{Value: []byte("output")})
+			return err
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		gomock.InOrder(
+			mockProducer.EXPECT().BeginTxn().Return(nil),
+			mockProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), nil),
+			mockProducer.EXPECT().AddMessageToTxn(gomock.Any(), consumer.consumerGroupName, nil).Return(nil),
+			mockProducer.EXPECT().CommitTxn().Return(nil),
+		)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Abort each failing attempt", func(t *testing.T) {
+		consumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			return handlerError
+		})
+		var messages = make(chan *sarama.ConsumerMessage)
+		fillMessageChannel(messages, "message")
+		mockConsumerGroupClaim.EXPECT().Messages().Return(messages)
+		mockProducer.EXPECT().BeginTxn().Return(nil).Times(2)
+		mockProducer.EXPECT().AbortTxn().Return(nil).Times(2)
+		mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "")
+
+		assert.Nil(t, consumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+	t.Run("Not transactional", func(t *testing.T) {
+		var msg = &consumedMessage{consumer: newConsumer(cluster, createDefaultConsumerConfiguration(), logger)}
+		assert.Nil(t, msg.GetTransactionalProducer())
+	})
 }
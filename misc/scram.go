@@ -0,0 +1,73 @@
+package misc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SecretProvider gets the current value of a secret. It is called each time the secret is used so that rotated secrets are taken
+// into account
+type SecretProvider func() (string, error)
+
+// SCRAMClient implements sarama.SCRAMClient
+type SCRAMClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	password      SecretProvider
+	conversation  *scram.ClientConversation
+}
+
+// NewSCRAMSHA256Client creates a SCRAM client using SHA-256
+func NewSCRAMSHA256Client() sarama.SCRAMClient {
+	return &SCRAMClient{hashGenerator: sha256.New}
+}
+
+// NewSCRAMSHA512Client creates a SCRAM client using SHA-512
+func NewSCRAMSHA512Client() sarama.SCRAMClient {
+	return &SCRAMClient{hashGenerator: sha512.New}
+}
+
+// NewSCRAMSHA256ClientGenerator creates a generator of SCRAM clients using SHA-256 which get the password from the given provider
+// instead of the sarama configuration
+func NewSCRAMSHA256ClientGenerator(password SecretProvider) func() sarama.SCRAMClient {
+	return func() sarama.SCRAMClient {
+		return &SCRAMClient{hashGenerator: sha256.New, password: password}
+	}
+}
+
+// NewSCRAMSHA512ClientGenerator creates a generator of SCRAM clients using SHA-512 which get the password from the given provider
+// instead of the sarama configuration
+func NewSCRAMSHA512ClientGenerator(password SecretProvider) func() sarama.SCRAMClient {
+	return func() sarama.SCRAMClient {
+		return &SCRAMClient{hashGenerator: sha512.New, password: password}
+	}
+}
+
+// Begin prepares the client for the SCRAM exchange with the server with a user name and a password. When the client has a password
+// provider, the given password is ignored
+func (c *SCRAMClient) Begin(userName, password, authzID string) error {
+	if c.password != nil {
+		var err error
+		if password, err = c.password(); err != nil {
+			return err
+		}
+	}
+	var client, err = c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step steps client through the SCRAM exchange. It is called repeatedly until it errors or Done returns true
+func (c *SCRAMClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done should return true when the SCRAM conversation is over
+func (c *SCRAMClient) Done() bool {
+	return c.conversation.Done()
+}
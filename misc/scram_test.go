@@ -0,0 +1,65 @@
+package misc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+func runSCRAMConversation(t *testing.T, client interface {
+	Begin(userName, password, authzID string) error
+	Step(challenge string) (string, error)
+	Done() bool
+}, hashGenerator scram.HashGeneratorFcn, password string) error {
+	var serverClient, err = hashGenerator.NewClient("user", "secret", "")
+	assert.Nil(t, err)
+	var credentials = serverClient.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+	server, err := hashGenerator.NewServer(func(string) (scram.StoredCredentials, error) { return credentials, nil })
+	assert.Nil(t, err)
+	var conversation = server.NewConversation()
+
+	if err = client.Begin("user", password, ""); err != nil {
+		return err
+	}
+	var challenge string
+	for !client.Done() {
+		var response, err = client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if conversation.Done() {
+			break
+		}
+		if challenge, err = conversation.Step(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSCRAMClient(t *testing.T) {
+	t.Run("SHA-256", func(t *testing.T) {
+		assert.Nil(t, runSCRAMConversation(t, NewSCRAMSHA256Client(), scram.SHA256, "secret"))
+	})
+	t.Run("SHA-512", func(t *testing.T) {
+		assert.Nil(t, runSCRAMConversation(t, NewSCRAMSHA512Client(), scram.SHA512, "secret"))
+	})
+	t.Run("Invalid password", func(t *testing.T) {
+		assert.NotNil(t, runSCRAMConversation(t, NewSCRAMSHA256Client(), scram.SHA256, "invalid"))
+	})
+	t.Run("Password provider", func(t *testing.T) {
+		var password = "secret"
+		var generator = NewSCRAMSHA512ClientGenerator(func() (string, error) { return password, nil })
+		// The password of the sarama configuration is ignored
+		assert.Nil(t, runSCRAMConversation(t, generator(), scram.SHA512, "ignored"))
+		password = "rotated"
+		assert.NotNil(t, runSCRAMConversation(t, generator(), scram.SHA512, "secret"))
+	})
+	t.Run("Password provider failure", func(t *testing.T) {
+		var anError = errors.New("secret not found")
+		var generator = NewSCRAMSHA256ClientGenerator(func() (string, error) { return "", anError })
+		assert.Equal(t, anError, runSCRAMConversation(t, generator(), scram.SHA256, "secret"))
+	})
+}
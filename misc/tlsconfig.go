@@ -0,0 +1,194 @@
+package misc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions describes the TLS configuration of a Kafka cluster
+type TLSOptions struct {
+	// CAFile is a PEM file containing the certificate authorities trusted to verify the brokers. It is reloaded when modified
+	CAFile string
+	// CAPEM contains PEM encoded certificate authorities trusted to verify the brokers
+	CAPEM string
+	// CertFile and KeyFile are the PEM files of the client certificate used for mutual TLS. They are reloaded when modified
+	CertFile string
+	KeyFile  string
+	// KeyPassphrase gets the passphrase of the encrypted key of KeyFile. It is called each time the key file is reloaded
+	KeyPassphrase SecretProvider
+	// ServerName overrides the name used to verify the certificates of the brokers
+	ServerName string
+	MinVersion uint16
+	// InsecureSkipVerify disables the verification of the certificates of the brokers. Only use it for development
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig creates a TLS configuration. Files are loaded immediately so that invalid files are detected at startup
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	var config = &tls.Config{
+		ServerName:         options.ServerName,
+		MinVersion:         options.MinVersion,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CertFile != "" {
+		var certificate = newReloadingFiles(func() (*tls.Certificate, error) {
+			return loadX509KeyPair(options.CertFile, options.KeyFile, options.KeyPassphrase)
+		}, options.CertFile, options.KeyFile)
+		if _, err := certificate.get(); err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %w", err)
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certificate.get()
+		}
+	}
+
+	switch {
+	case options.InsecureSkipVerify:
+	case options.CAPEM != "":
+		var pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(options.CAPEM)) {
+			return nil, errors.New("can't load CA certificates from PEM")
+		}
+		config.RootCAs = pool
+	case options.CAFile != "":
+		var roots = newReloadingFiles(func() (*x509.CertPool, error) {
+			var content, err = os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			var pool = x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("no CA certificate found in %s", options.CAFile)
+			}
+			return pool, nil
+		}, options.CAFile)
+		if _, err := roots.get(); err != nil {
+			return nil, fmt.Errorf("can't load CA certificates: %w", err)
+		}
+		// RootCAs can't be updated once the configuration is in use: certificates are verified by VerifyConnection with the
+		// current CA certificates instead
+		config.InsecureSkipVerify = true // Verification done by VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			var pool, err = roots.get()
+			if err != nil {
+				return err
+			}
+			return verifyPeerCertificates(state, pool)
+		}
+	}
+	return config, nil
+}
+
+func verifyPeerCertificates(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate presented by the server")
+	}
+	var intermediates = x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	var _, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       state.ServerName,
+	})
+	return err
+}
+
+// loadX509KeyPair loads a certificate and its key, decrypting the key with the passphrase if it is encrypted
+func loadX509KeyPair(certFile, keyFile string, passphrase SecretProvider) (*tls.Certificate, error) {
+	var certPEM, err = os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if keyPEM, err = decryptPEMKey(keyPEM, passphrase); err != nil {
+		return nil, err
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	return &certificate, err
+}
+
+// decryptPEMKey decrypts a key encrypted with the legacy PEM encryption (RFC 1423), the only one supported by the standard library.
+// Unencrypted keys are returned unchanged
+func decryptPEMKey(keyPEM []byte, passphrase SecretProvider) ([]byte, error) {
+	var block, _ = pem.Decode(keyPEM)
+	if block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("unsupported key encryption: encrypted PKCS#8 keys are not supported, use the legacy PEM encryption or an unencrypted key")
+	}
+	if block == nil || !x509.IsEncryptedPEMBlock(block) {
+		return keyPEM, nil
+	}
+	if passphrase == nil {
+		return nil, errors.New("key is encrypted but no passphrase is configured")
+	}
+	var secret, err = passphrase()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// reloadingFiles caches a value loaded from files and loads it again when one of the files is modified. When the files can't be
+// loaded anymore (e.g. while they are being replaced), the last loaded value is kept
+type reloadingFiles[T any] struct {
+	files   []string
+	load    func() (T, error)
+	mutex   sync.Mutex
+	loaded  bool
+	modTime time.Time
+	value   T
+}
+
+func newReloadingFiles[T any](load func() (T, error), files ...string) *reloadingFiles[T] {
+	return &reloadingFiles[T]{files: files, load: load}
+}
+
+func (rf *reloadingFiles[T]) get() (T, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	var modTime time.Time
+	for _, file := range rf.files {
+		var info, err = os.Stat(file)
+		if err != nil {
+			return rf.keepLoaded(err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if rf.loaded && modTime.Equal(rf.modTime) {
+		return rf.value, nil
+	}
+	var value, err = rf.load()
+	if err != nil {
+		return rf.keepLoaded(err)
+	}
+	rf.loaded = true
+	rf.modTime = modTime
+	rf.value = value
+	return value, nil
+}
+
+func (rf *reloadingFiles[T]) keepLoaded(err error) (T, error) {
+	if rf.loaded {
+		return rf.value, nil
+	}
+	var zero T
+	return zero, err
+}
@@ -0,0 +1,222 @@
+package misc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+func newTestCertificate(t *testing.T, commonName string, issuer *testCertificate) *testCertificate {
+	var key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	var parent, signer = template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	assert.Nil(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (tc *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	var certificate, err = tls.X509KeyPair(tc.certPEM, tc.keyPEM)
+	assert.Nil(t, err)
+	return certificate
+}
+
+// writeFile writes a file and makes sure its modification time changes
+func writeFile(t *testing.T, file string, content []byte, modTime time.Time) {
+	assert.Nil(t, os.WriteFile(file, content, 0600))
+	assert.Nil(t, os.Chtimes(file, modTime, modTime))
+}
+
+// handshake connects a client using the given configuration to a server presenting the given certificate. It returns the client
+// certificate received by the server, if any
+func handshake(t *testing.T, clientConfig *tls.Config, serverCertificate tls.Certificate, clientCAs *x509.CertPool) (*x509.Certificate, error) {
+	var listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	})
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	var serverResult = make(chan *x509.Certificate, 1)
+	go func() {
+		var conn, err = listener.Accept()
+		if err != nil {
+			serverResult <- nil
+			return
+		}
+		defer conn.Close()
+		var server = conn.(*tls.Conn)
+		if server.Handshake() != nil || len(server.ConnectionState().PeerCertificates) == 0 {
+			serverResult <- nil
+			return
+		}
+		serverResult <- server.ConnectionState().PeerCertificates[0]
+	}()
+
+	var config = clientConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = "broker.local"
+	}
+	client, err := tls.Dial("tcp", listener.Addr().String(), config)
+	if err == nil {
+		// Completes the handshake on the server side before closing the connection
+		_, _ = client.Write([]byte("ping"))
+		defer client.Close()
+	}
+	return <-serverResult, err
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	var dir = t.TempDir()
+	var ca = newTestCertificate(t, "ca", nil)
+	var otherCA = newTestCertificate(t, "other-ca", nil)
+	var broker = newTestCertificate(t, "broker.local", ca)
+	var otherBroker = newTestCertificate(t, "broker.local", otherCA)
+	var caPool = x509.NewCertPool()
+	caPool.AddCert(ca.certificate)
+
+	var caFile = filepath.Join(dir, "ca.pem")
+	var certFile = filepath.Join(dir, "client.pem")
+	var keyFile = filepath.Join(dir, "client.key")
+	var now = time.Now()
+	writeFile(t, caFile, ca.certPEM, now)
+
+	t.Run("Invalid files", func(t *testing.T) {
+		var _, err = NewTLSConfig(TLSOptions{CAFile: filepath.Join(dir, "missing.pem")})
+		assert.NotNil(t, err)
+		_, err = NewTLSConfig(TLSOptions{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile})
+		assert.NotNil(t, err)
+		_, err = NewTLSConfig(TLSOptions{CAPEM: "not a certificate"})
+		assert.NotNil(t, err)
+	})
+	t.Run("CA PEM", func(t *testing.T) {
+		var config, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), MinVersion: tls.VersionTLS12})
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		_, err = handshake(t, config, broker.tlsCertificate(t), nil)
+		assert.Nil(t, err)
+		_, err = handshake(t, config, otherBroker.tlsCertificate(t), nil)
+		assert.NotNil(t, err)
+	})
+	t.Run("Server name", func(t *testing.T) {
+		var config, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), ServerName: "other.local"})
+		assert.Nil(t, err)
+		_, err = handshake(t, config, broker.tlsCertificate(t), nil)
+		assert.NotNil(t, err)
+	})
+	t.Run("Insecure skip verify", func(t *testing.T) {
+		var config, err = NewTLSConfig(TLSOptions{CAFile: caFile, InsecureSkipVerify: true})
+		assert.Nil(t, err)
+		assert.Nil(t, config.VerifyConnection)
+		_, err = handshake(t, config, otherBroker.tlsCertificate(t), nil)
+		assert.Nil(t, err)
+	})
+	t.Run("CA file reloaded", func(t *testing.T) {
+		var config, err = NewTLSConfig(TLSOptions{CAFile: caFile})
+		assert.Nil(t, err)
+		_, err = handshake(t, config, broker.tlsCertificate(t), nil)
+		assert.Nil(t, err)
+		_, err = handshake(t, config, otherBroker.tlsCertificate(t), nil)
+		assert.NotNil(t, err)
+
+		writeFile(t, caFile, otherCA.certPEM, now.Add(time.Minute))
+		_, err = handshake(t, config, otherBroker.tlsCertificate(t), nil)
+		assert.Nil(t, err)
+
+		// Invalid content while the file is being replaced: last loaded CA is kept
+		writeFile(t, caFile, []byte("partial"), now.Add(2*time.Minute))
+		_, err = handshake(t, config, otherBroker.tlsCertificate(t), nil)
+		assert.Nil(t, err)
+	})
+	t.Run("Client certificate reloaded", func(t *testing.T) {
+		var client = newTestCertificate(t, "client-1", ca)
+		writeFile(t, certFile, client.certPEM, now)
+		writeFile(t, keyFile, client.keyPEM, now)
+
+		var config, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), CertFile: certFile, KeyFile: keyFile})
+		assert.Nil(t, err)
+		received, err := handshake(t, config, broker.tlsCertificate(t), caPool)
+		assert.Nil(t, err)
+		assert.Equal(t, "client-1", received.Subject.CommonName)
+
+		var renewed = newTestCertificate(t, "client-2", ca)
+		writeFile(t, certFile, renewed.certPEM, now.Add(time.Minute))
+		writeFile(t, keyFile, renewed.keyPEM, now.Add(time.Minute))
+		received, err = handshake(t, config, broker.tlsCertificate(t), caPool)
+		assert.Nil(t, err)
+		assert.Equal(t, "client-2", received.Subject.CommonName)
+	})
+	t.Run("Encrypted key", func(t *testing.T) {
+		var client = newTestCertificate(t, "client-encrypted", ca)
+		var block, _ = pem.Decode(client.keyPEM)
+		encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("passphrase"), x509.PEMCipherAES256)
+		assert.Nil(t, err)
+		writeFile(t, certFile, client.certPEM, now.Add(2*time.Minute))
+		writeFile(t, keyFile, pem.EncodeToMemory(encrypted), now.Add(2*time.Minute))
+
+		_, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), CertFile: certFile, KeyFile: keyFile})
+		assert.NotNil(t, err)
+		_, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), CertFile: certFile, KeyFile: keyFile,
+			KeyPassphrase: func() (string, error) { return "invalid", nil }})
+		assert.NotNil(t, err)
+
+		config, err := NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), CertFile: certFile, KeyFile: keyFile,
+			KeyPassphrase: func() (string, error) { return "passphrase", nil }})
+		assert.Nil(t, err)
+		received, err := handshake(t, config, broker.tlsCertificate(t), caPool)
+		assert.Nil(t, err)
+		assert.Equal(t, "client-encrypted", received.Subject.CommonName)
+	})
+	t.Run("Encrypted PKCS#8 key", func(t *testing.T) {
+		var client = newTestCertificate(t, "client-pkcs8", ca)
+		writeFile(t, certFile, client.certPEM, now.Add(3*time.Minute))
+		writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte("encrypted")}), now.Add(3*time.Minute))
+
+		var _, err = NewTLSConfig(TLSOptions{CAPEM: string(ca.certPEM), CertFile: certFile, KeyFile: keyFile,
+			KeyPassphrase: func() (string, error) { return "passphrase", nil }})
+		assert.ErrorContains(t, err, "unsupported key encryption")
+	})
+}
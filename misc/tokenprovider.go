@@ -2,6 +2,11 @@ package misc
 
 import (
 	"context"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"golang.org/x/oauth2"
@@ -10,28 +15,299 @@ import (
 
 // TokenProvider struct
 type TokenProvider struct {
-	tokenSource oauth2.TokenSource
+	tokenSource   oauth2.TokenSource
+	extensions    map[string]string
+	refreshBefore time.Duration
+	refreshJitter time.Duration
+	fetchRetries  int
+	fetchBackoff  time.Duration
+	metrics       TokenMetrics
+	mutex         sync.Mutex
+	token         *oauth2.Token
+	refreshAt     time.Time
+	// refreshing is true while a token is fetched in the background
+	refreshing bool
+	timer      *time.Timer
+	// fetchMutex serializes the fetches done by callers which have no valid token
+	fetchMutex sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// maxFetchBackoff limits the delay between two attempts to fetch a token
+const maxFetchBackoff = 5 * time.Second
+
+// TokenMetrics receives measures about the tokens fetched by a TokenProvider
+type TokenMetrics interface {
+	// TokenFetched is called when a token has been obtained. Expiry is zero when the token has no known expiry
+	TokenFetched(latency time.Duration, expiry time.Time)
+	// TokenFetchFailed is called each time an attempt to obtain a token fails
+	TokenFetchFailed(latency time.Duration, err error)
+}
+
+// TokenProviderConfig describes how access tokens are obtained from the token endpoint
+type TokenProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+	// ClientSecretProvider, when set, is called before each request to the token endpoint and overrides ClientSecret
+	ClientSecretProvider SecretProvider
+	TokenURL             string
+	Scopes               []string
+	// EndpointParams are additional parameters sent to the token endpoint (e.g. audience)
+	EndpointParams url.Values
+	AuthStyle      oauth2.AuthStyle
+	// HTTPTimeout limits the duration of the requests to the token endpoint. No limit when zero
+	HTTPTimeout time.Duration
+	// TLS is the TLS configuration used to connect to the token endpoint. The default one is used when nil
+	TLS *TLSOptions
+	// Extensions are SASL extensions sent to the brokers with the access token
+	Extensions map[string]string
+	// PrivateKeyFile is a PEM file containing the RSA or ECDSA P-256 key used to sign client assertions (private_key_jwt). When set,
+	// no client secret is sent. The file is reloaded when modified
+	PrivateKeyFile string
+	// KeyID is the kid header of the client assertions
+	KeyID string
+	// TokenFile is a file containing a token refreshed by an external process. When set, no token endpoint is called and the file
+	// is read again when modified
+	TokenFile string
+	// RefreshBefore is the duration before the expiry of the cached token from which a new token is fetched. It is limited to half the
+	// lifetime of the token. Tokens are cached until they expire when zero
+	RefreshBefore time.Duration
+	// RefreshJitter is the maximum random duration added to RefreshBefore so that instances don't refresh their tokens simultaneously
+	RefreshJitter time.Duration
+	// FetchRetries is the number of additional attempts done when a token can't be fetched
+	FetchRetries int
+	// FetchBackoff is the delay before the first retry. It doubles after each attempt, up to 5s
+	FetchBackoff time.Duration
+	// Metrics receives measures about the fetched tokens. Optional
+	Metrics TokenMetrics
 }
 
 // NewTokenProvider creates an instance of sarama AccessTokenProvider
 func NewTokenProvider(clientID, clientSecret, tokenURL string) sarama.AccessTokenProvider {
-	cfg := clientcredentials.Config{
+	var tokenProvider, _ = NewTokenProviderWithConfig(TokenProviderConfig{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		TokenURL:     tokenURL,
-	}
+	})
+	return tokenProvider
+}
 
+// NewTokenProviderWithConfig creates an instance of sarama AccessTokenProvider from a complete configuration. Tokens are cached and
+// refreshed in the background before they expire. When a token can't be refreshed, the cached one is used as long as it is valid.
+// The returned provider is a *TokenProvider which should be closed once it is not used anymore
+func NewTokenProviderWithConfig(config TokenProviderConfig) (sarama.AccessTokenProvider, error) {
+	var tokenSource, err = newTokenSource(config)
+	if err != nil {
+		return nil, err
+	}
 	return &TokenProvider{
-		tokenSource: cfg.TokenSource(context.Background()),
+		tokenSource:   tokenSource,
+		extensions:    config.Extensions,
+		refreshBefore: config.RefreshBefore,
+		refreshJitter: config.RefreshJitter,
+		fetchRetries:  config.FetchRetries,
+		fetchBackoff:  config.FetchBackoff,
+		metrics:       config.Metrics,
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// newTokenSource creates a token source which obtains a new token each time it is called
+func newTokenSource(config TokenProviderConfig) (oauth2.TokenSource, error) {
+	if config.TokenFile != "" {
+		return newFileTokenSource(config.TokenFile)
 	}
+
+	cfg := clientcredentials.Config{
+		ClientID:       config.ClientID,
+		ClientSecret:   config.ClientSecret,
+		TokenURL:       config.TokenURL,
+		Scopes:         config.Scopes,
+		EndpointParams: config.EndpointParams,
+		AuthStyle:      config.AuthStyle,
+	}
+
+	var ctx = context.Background()
+	if config.HTTPTimeout > 0 || config.TLS != nil {
+		var transport = http.DefaultTransport.(*http.Transport).Clone()
+		if config.TLS != nil {
+			var tlsConfig, err = NewTLSConfig(*config.TLS)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+		}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+			Transport: transport,
+			Timeout:   config.HTTPTimeout,
+		})
+	}
+
+	if config.PrivateKeyFile != "" {
+		return newAssertionTokenSource(ctx, cfg, config.PrivateKeyFile, config.KeyID)
+	}
+	return &clientCredentialsTokenSource{ctx: ctx, config: cfg, clientSecret: config.ClientSecretProvider}, nil
 }
 
-// Token returns a new *sarama.AccessToken or an error as appropriate.
+// clientCredentialsTokenSource obtains tokens with the client credentials grant. Contrary to the token source of clientcredentials,
+// it does not cache tokens: caching is done by the TokenProvider
+type clientCredentialsTokenSource struct {
+	ctx          context.Context
+	config       clientcredentials.Config
+	clientSecret SecretProvider
+}
+
+// Token requests a new token to the token endpoint
+func (s *clientCredentialsTokenSource) Token() (*oauth2.Token, error) {
+	var config = s.config
+	if s.clientSecret != nil {
+		var secret, err = s.clientSecret()
+		if err != nil {
+			return nil, err
+		}
+		config.ClientSecret = secret
+	}
+	return config.Token(s.ctx)
+}
+
+// Token returns a new *sarama.AccessToken or an error as appropriate. The cached token is returned while it is valid: callers only wait
+// for a token to be fetched when none is valid
 func (t *TokenProvider) Token() (*sarama.AccessToken, error) {
-	token, err := t.tokenSource.Token()
+	if token := t.cachedToken(); token != nil {
+		return t.accessToken(token), nil
+	}
+
+	t.fetchMutex.Lock()
+	defer t.fetchMutex.Unlock()
+	if token := t.cachedToken(); token != nil {
+		// Fetched by another caller in the meantime
+		return t.accessToken(token), nil
+	}
+	var token, err = t.fetch()
 	if err != nil {
 		return nil, err
 	}
+	t.store(token)
+	return t.accessToken(token), nil
+}
+
+// Close stops refreshing the token and cancels the pending retries
+func (t *TokenProvider) Close() error {
+	t.closeOnce.Do(func() {
+		if t.done != nil {
+			close(t.done)
+		}
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+	})
+	return nil
+}
+
+func (t *TokenProvider) accessToken(token *oauth2.Token) *sarama.AccessToken {
+	return &sarama.AccessToken{Token: token.AccessToken, Extensions: t.extensions}
+}
+
+// cachedToken returns the cached token if it is still valid, nil otherwise. Once its refresh time is reached, a new token is fetched
+// in the background
+func (t *TokenProvider) cachedToken() *oauth2.Token {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var now = time.Now()
+	if t.token == nil || !now.Before(t.token.Expiry) {
+		return nil
+	}
+	if !now.Before(t.refreshAt) {
+		t.refreshInBackground()
+	}
+	return t.token
+}
+
+// store caches a new token, unless it has no expiry, and schedules its refresh
+func (t *TokenProvider) store(token *oauth2.Token) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.token = nil
+	if token.Expiry.IsZero() {
+		return
+	}
+	t.token = token
+	t.refreshAt = t.nextRefresh(time.Now(), token.Expiry)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.refreshAt.Before(token.Expiry) && !t.isClosed() {
+		t.timer = time.AfterFunc(time.Until(t.refreshAt), func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.refreshInBackground()
+		})
+	}
+}
 
-	return &sarama.AccessToken{Token: token.AccessToken}, nil
+// refreshInBackground fetches a new token unless it is already being fetched. The caller must hold the mutex
+func (t *TokenProvider) refreshInBackground() {
+	if t.refreshing || t.isClosed() {
+		return
+	}
+	t.refreshing = true
+	go func() {
+		var token, err = t.fetch()
+		t.mutex.Lock()
+		t.refreshing = false
+		t.mutex.Unlock()
+		if err == nil {
+			t.store(token)
+		}
+	}()
+}
+
+func (t *TokenProvider) isClosed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// fetch gets a new token from the token source, retrying with an exponential backoff when it fails. Retries stop when the provider
+// is closed
+func (t *TokenProvider) fetch() (*oauth2.Token, error) {
+	var backoff = min(t.fetchBackoff, maxFetchBackoff)
+	for attempt := 0; ; attempt++ {
+		var start = time.Now()
+		var token, err = t.tokenSource.Token()
+		var latency = time.Since(start)
+		if err == nil {
+			if t.metrics != nil {
+				t.metrics.TokenFetched(latency, token.Expiry)
+			}
+			return token, nil
+		}
+		if t.metrics != nil {
+			t.metrics.TokenFetchFailed(latency, err)
+		}
+		if attempt >= t.fetchRetries {
+			return nil, err
+		}
+		select {
+		case <-t.done:
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxFetchBackoff)
+	}
+}
+
+// nextRefresh computes when a token expiring at the given time should be refreshed
+func (t *TokenProvider) nextRefresh(now time.Time, expiry time.Time) time.Time {
+	var before = t.refreshBefore
+	if t.refreshJitter > 0 {
+		before += rand.N(t.refreshJitter)
+	}
+	return expiry.Add(-min(before, expiry.Sub(now)/2))
 }
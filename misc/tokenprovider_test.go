@@ -1,8 +1,14 @@
 package misc
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/cloudtrust/kafka-client/misc/mock"
 	"github.com/stretchr/testify/assert"
@@ -45,3 +51,275 @@ func TestTokenProvider(t *testing.T) {
 		assert.Equal(t, aToken.AccessToken, res.Token)
 	})
 }
+
+// newTokenServer starts a token endpoint which gives the received requests to the test
+func newTokenServer(t *testing.T, requests chan<- *http.Request) *httptest.Server {
+	var server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		requests <- r
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "a-token-only-valid-for-tests", "token_type": "bearer", "expires_in": 3600})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTokenProviderWithConfig(t *testing.T) {
+	var requests = make(chan *http.Request, 1)
+
+	t.Run("Scopes, endpoint params and extensions", func(t *testing.T) {
+		var server = newTokenServer(t, requests)
+		server.Start()
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{
+			ClientID:       "clientID",
+			ClientSecret:   "clientSecret",
+			TokenURL:       server.URL,
+			Scopes:         []string{"kafka", "profile"},
+			EndpointParams: url.Values{"audience": []string{"kafka-cluster"}},
+			AuthStyle:      oauth2.AuthStyleInParams,
+			HTTPTimeout:    5 * time.Second,
+			Extensions:     map[string]string{"logicalCluster": "lkc-1"},
+		})
+		assert.Nil(t, err)
+
+		token, err := tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "a-token-only-valid-for-tests", token.Token)
+		assert.Equal(t, map[string]string{"logicalCluster": "lkc-1"}, token.Extensions)
+
+		var request = <-requests
+		assert.Equal(t, "kafka profile", request.PostForm.Get("scope"))
+		assert.Equal(t, "kafka-cluster", request.PostForm.Get("audience"))
+		assert.Equal(t, "clientID", request.PostForm.Get("client_id"))
+		assert.Equal(t, "clientSecret", request.PostForm.Get("client_secret"))
+	})
+	t.Run("Client secret provider", func(t *testing.T) {
+		var server = newTokenServer(t, requests)
+		server.Start()
+		var clientSecret = "first-secret"
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{
+			ClientID:             "clientID",
+			ClientSecret:         "ignored",
+			ClientSecretProvider: func() (string, error) { return clientSecret, nil },
+			TokenURL:             server.URL,
+			AuthStyle:            oauth2.AuthStyleInParams,
+		})
+		assert.Nil(t, err)
+		for _, secret := range []string{"first-secret", "rotated-secret"} {
+			clientSecret = secret
+			tp.(*TokenProvider).token = nil // Forces a new request to the token endpoint
+			_, err = tp.Token()
+			assert.Nil(t, err)
+			assert.Equal(t, secret, (<-requests).PostForm.Get("client_secret"))
+		}
+	})
+	t.Run("Credentials in header", func(t *testing.T) {
+		var server = newTokenServer(t, requests)
+		server.Start()
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{
+			ClientID:     "clientID",
+			ClientSecret: "clientSecret",
+			TokenURL:     server.URL,
+			AuthStyle:    oauth2.AuthStyleInHeader,
+		})
+		assert.Nil(t, err)
+		_, err = tp.Token()
+		assert.Nil(t, err)
+
+		var request = <-requests
+		var user, password, ok = request.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "clientID", user)
+		assert.Equal(t, "clientSecret", password)
+		assert.Empty(t, request.PostForm.Get("client_secret"))
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer server.Close()
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL, HTTPTimeout: 10 * time.Millisecond})
+		assert.Nil(t, err)
+		_, err = tp.Token()
+		assert.NotNil(t, err)
+	})
+	t.Run("TLS", func(t *testing.T) {
+		var ca = newTestCertificate(t, "ca", nil)
+		var idp = newTestCertificate(t, "idp.local", ca)
+		var server = newTokenServer(t, requests)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{idp.tlsCertificate(t)}}
+		server.StartTLS()
+
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL,
+			TLS: &TLSOptions{CAPEM: string(ca.certPEM), ServerName: "idp.local"}})
+		assert.Nil(t, err)
+		_, err = tp.Token()
+		assert.Nil(t, err)
+		<-requests
+
+		// Unknown certificate authority
+		tp, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL,
+			TLS: &TLSOptions{CAPEM: string(newTestCertificate(t, "other-ca", nil).certPEM), ServerName: "idp.local"}})
+		assert.Nil(t, err)
+		_, err = tp.Token()
+		assert.NotNil(t, err)
+	})
+	t.Run("Invalid TLS", func(t *testing.T) {
+		var _, err = NewTokenProviderWithConfig(TokenProviderConfig{TLS: &TLSOptions{CAPEM: "not a certificate"}})
+		assert.NotNil(t, err)
+	})
+}
+
+type recordedMetrics struct {
+	fetched  []time.Time
+	failures []error
+}
+
+func (m *recordedMetrics) TokenFetched(_ time.Duration, expiry time.Time) {
+	m.fetched = append(m.fetched, expiry)
+}
+
+func (m *recordedMetrics) TokenFetchFailed(_ time.Duration, err error) {
+	m.failures = append(m.failures, err)
+}
+
+func TestTokenProviderCache(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockTokenSource = mock.NewTokenSource(mockCtrl)
+	var anError = errors.New("identity provider unavailable")
+
+	var newProvider = func(metrics *recordedMetrics) *TokenProvider {
+		return &TokenProvider{
+			tokenSource:   mockTokenSource,
+			refreshBefore: time.Minute,
+			refreshJitter: 10 * time.Second,
+			fetchRetries:  2,
+			fetchBackoff:  time.Millisecond,
+			metrics:       metrics,
+		}
+	}
+
+	t.Run("Token reused until refresh", func(t *testing.T) {
+		var metrics = &recordedMetrics{}
+		var tp = newProvider(metrics)
+		var expiry = time.Now().Add(time.Hour)
+		mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "first", Expiry: expiry}, nil)
+		for range 3 {
+			var token, err = tp.Token()
+			assert.Nil(t, err)
+			assert.Equal(t, "first", token.Token)
+		}
+		assert.Equal(t, []time.Time{expiry}, metrics.fetched)
+		assert.True(t, tp.refreshAt.After(expiry.Add(-time.Minute-10*time.Second)))
+		assert.False(t, tp.refreshAt.After(expiry.Add(-time.Minute)))
+
+		// Refresh time reached: the cached token is still returned while a new one is fetched in the background
+		tp.refreshAt = time.Now()
+		mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "second", Expiry: expiry}, nil)
+		var token, err = tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "first", token.Token)
+		assert.Eventually(t, func() bool { return cachedToken(tp) == "second" }, time.Second, time.Millisecond)
+		tp.Close()
+	})
+	t.Run("Proactive refresh", func(t *testing.T) {
+		var tp = newProvider(&recordedMetrics{})
+		defer tp.Close()
+		gomock.InOrder(
+			mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "first", Expiry: time.Now().Add(100 * time.Millisecond)}, nil),
+			mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "second", Expiry: time.Now().Add(time.Hour)}, nil),
+		)
+		var _, err = tp.Token()
+		assert.Nil(t, err)
+		// Refreshed at half its lifetime without any call to Token
+		assert.Eventually(t, func() bool { return cachedToken(tp) == "second" }, time.Second, time.Millisecond)
+	})
+	t.Run("Short lived token refreshed at half its lifetime", func(t *testing.T) {
+		var tp = newProvider(&recordedMetrics{})
+		var now = time.Now()
+		mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "short", Expiry: now.Add(30 * time.Second)}, nil)
+		var _, err = tp.Token()
+		assert.Nil(t, err)
+		assert.True(t, tp.refreshAt.After(now.Add(14*time.Second)))
+		assert.True(t, tp.refreshAt.Before(now.Add(16*time.Second)))
+	})
+	t.Run("Token without expiry not cached", func(t *testing.T) {
+		var tp = newProvider(&recordedMetrics{})
+		mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "no-expiry"}, nil).Times(2)
+		for range 2 {
+			var token, err = tp.Token()
+			assert.Nil(t, err)
+			assert.Equal(t, "no-expiry", token.Token)
+		}
+	})
+	t.Run("Retry", func(t *testing.T) {
+		var metrics = &recordedMetrics{}
+		var tp = newProvider(metrics)
+		gomock.InOrder(
+			mockTokenSource.EXPECT().Token().Return(nil, anError),
+			mockTokenSource.EXPECT().Token().Return(&oauth2.Token{AccessToken: "retried", Expiry: time.Now().Add(time.Hour)}, nil),
+		)
+		var token, err = tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "retried", token.Token)
+		assert.Equal(t, []error{anError}, metrics.failures)
+	})
+	t.Run("Retries exhausted", func(t *testing.T) {
+		var metrics = &recordedMetrics{}
+		var tp = newProvider(metrics)
+		mockTokenSource.EXPECT().Token().Return(nil, anError).Times(3)
+		var _, err = tp.Token()
+		assert.Equal(t, anError, err)
+		assert.Len(t, metrics.failures, 3)
+	})
+	t.Run("Last known good token", func(t *testing.T) {
+		var metrics = &recordedMetrics{}
+		var tp = newProvider(metrics)
+		tp.token = &oauth2.Token{AccessToken: "last-known-good", Expiry: time.Now().Add(time.Minute)}
+		tp.refreshAt = time.Now()
+		var failures = make(chan struct{}, 3)
+		mockTokenSource.EXPECT().Token().DoAndReturn(func() (*oauth2.Token, error) {
+			failures <- struct{}{}
+			return nil, anError
+		}).Times(3)
+		var token, err = tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "last-known-good", token.Token)
+		for range 3 {
+			<-failures
+		}
+		assert.Eventually(t, func() bool {
+			tp.mutex.Lock()
+			defer tp.mutex.Unlock()
+			return !tp.refreshing
+		}, time.Second, time.Millisecond)
+
+		// Expired token is not used
+		tp.token.Expiry = time.Now()
+		mockTokenSource.EXPECT().Token().Return(nil, anError).Times(3)
+		_, err = tp.Token()
+		assert.Equal(t, anError, err)
+	})
+	t.Run("Retries canceled when closed", func(t *testing.T) {
+		var tp = newProvider(&recordedMetrics{})
+		tp.done = make(chan struct{})
+		tp.fetchBackoff = time.Hour
+		mockTokenSource.EXPECT().Token().DoAndReturn(func() (*oauth2.Token, error) {
+			go tp.Close()
+			return nil, anError
+		})
+		var _, err = tp.Token()
+		assert.Equal(t, anError, err)
+	})
+}
+
+func cachedToken(tp *TokenProvider) string {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	if tp.token == nil {
+		return ""
+	}
+	return tp.token.AccessToken
+}
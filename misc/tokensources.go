@@ -0,0 +1,193 @@
+package misc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// assertionLifetime is the validity of the signed assertions. A new assertion is signed for each token request
+	assertionLifetime = 5 * time.Minute
+)
+
+// assertionTokenSource obtains tokens with the client credentials grant, authenticating the client with a JWT assertion signed
+// with its private key (private_key_jwt)
+type assertionTokenSource struct {
+	ctx    context.Context
+	config clientcredentials.Config
+	keyID  string
+	key    *reloadingFiles[crypto.Signer]
+}
+
+func newAssertionTokenSource(ctx context.Context, config clientcredentials.Config, privateKeyFile string, keyID string) (*assertionTokenSource, error) {
+	var key = newReloadingFiles(func() (crypto.Signer, error) {
+		return loadPrivateKey(privateKeyFile)
+	}, privateKeyFile)
+	if _, err := key.get(); err != nil {
+		return nil, err
+	}
+	// The client is authenticated by the assertion: no client secret is sent
+	config.ClientSecret = ""
+	config.AuthStyle = oauth2.AuthStyleInParams
+	return &assertionTokenSource{ctx: ctx, config: config, keyID: keyID, key: key}, nil
+}
+
+// Token requests a new token to the token endpoint
+func (s *assertionTokenSource) Token() (*oauth2.Token, error) {
+	var key, err = s.key.get()
+	if err != nil {
+		return nil, err
+	}
+	var now = time.Now()
+	assertion, err := signJWT(key, s.keyID, map[string]any{
+		"iss": s.config.ClientID,
+		"sub": s.config.ClientID,
+		"aud": s.config.TokenURL,
+		"jti": uuid.New().String(),
+		"iat": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var config = s.config
+	config.EndpointParams = url.Values{}
+	for name, values := range s.config.EndpointParams {
+		config.EndpointParams[name] = values
+	}
+	config.EndpointParams.Set("client_assertion_type", clientAssertionType)
+	config.EndpointParams.Set("client_assertion", assertion)
+	return config.Token(s.ctx)
+}
+
+// fileTokenSource reads a token issued by an external process (e.g. a Kubernetes projected service account token). The file is
+// read again when it is modified
+type fileTokenSource struct {
+	token *reloadingFiles[*oauth2.Token]
+}
+
+func newFileTokenSource(tokenFile string) (*fileTokenSource, error) {
+	var token = newReloadingFiles(func() (*oauth2.Token, error) {
+		var content, err = os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		var accessToken = strings.TrimSpace(string(content))
+		if accessToken == "" {
+			return nil, fmt.Errorf("token file %s is empty", tokenFile)
+		}
+		return &oauth2.Token{AccessToken: accessToken}, nil
+	}, tokenFile)
+	if _, err := token.get(); err != nil {
+		return nil, err
+	}
+	return &fileTokenSource{token: token}, nil
+}
+
+// Token returns the token read from the file
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	return s.token.get()
+}
+
+// loadPrivateKey reads a PEM encoded RSA or ECDSA P-256 private key (PKCS#8, PKCS#1 or SEC 1)
+func loadPrivateKey(file string) (crypto.Signer, error) {
+	var content, err = os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var block, _ = pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	var key any
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("unsupported private key in %s", file)
+			}
+		}
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key in %s", file)
+	}
+}
+
+// signJWT creates a compact JWS signed with RS256 or ES256 depending on the type of the key
+func signJWT(key crypto.Signer, keyID string, claims map[string]any) (string, error) {
+	var header = map[string]string{"typ": "JWT"}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		header["alg"] = "RS256"
+	case *ecdsa.PrivateKey:
+		header["alg"] = "ES256"
+	default:
+		return "", errors.New("unsupported private key")
+	}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	var encodedHeader, err = encodeJWTPart(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeJWTPart(claims)
+	if err != nil {
+		return "", err
+	}
+	var signingInput = encodedHeader + "." + encodedClaims
+	var digest = sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		var r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeJWTPart(value any) (string, error) {
+	var content, err = json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(content), nil
+}
@@ -0,0 +1,153 @@
+package misc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyJWT checks the signature of a compact JWS and returns its header and claims
+func verifyJWT(t *testing.T, jwt string, publicKey crypto.PublicKey) (map[string]any, map[string]any) {
+	var parts = strings.Split(jwt, ".")
+	assert.Len(t, parts, 3)
+	var digest = sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	var signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Nil(t, err)
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		assert.Nil(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+	case *ecdsa.PublicKey:
+		assert.Len(t, signature, 64)
+		var r, s = new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		assert.True(t, ecdsa.Verify(key, digest[:], r, s))
+	}
+	var header, claims map[string]any
+	for i, part := range []*map[string]any{&header, &claims} {
+		var content, err = base64.RawURLEncoding.DecodeString(parts[i])
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(content, part))
+	}
+	return header, claims
+}
+
+func TestPrivateKeyJWT(t *testing.T) {
+	var dir = t.TempDir()
+	var rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	assert.Nil(t, err)
+
+	var rsaFile = filepath.Join(dir, "rsa.key")
+	var ecFile = filepath.Join(dir, "ec.key")
+	writeFile(t, rsaFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), time.Now())
+	writeFile(t, ecFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), time.Now())
+
+	var requests = make(chan *http.Request, 1)
+	var server = newTokenServer(t, requests)
+	server.Start()
+
+	for algorithm, key := range map[string]struct {
+		file      string
+		publicKey crypto.PublicKey
+	}{
+		"RS256": {file: rsaFile, publicKey: &rsaKey.PublicKey},
+		"ES256": {file: ecFile, publicKey: &ecKey.PublicKey},
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{
+				ClientID:       "clientID",
+				TokenURL:       server.URL,
+				PrivateKeyFile: key.file,
+				KeyID:          "key-1",
+			})
+			assert.Nil(t, err)
+			token, err := tp.Token()
+			assert.Nil(t, err)
+			assert.Equal(t, "a-token-only-valid-for-tests", token.Token)
+
+			var request = <-requests
+			assert.Equal(t, "client_credentials", request.PostForm.Get("grant_type"))
+			assert.Equal(t, clientAssertionType, request.PostForm.Get("client_assertion_type"))
+			assert.Empty(t, request.PostForm.Get("client_secret"))
+			var _, _, basicAuth = request.BasicAuth()
+			assert.False(t, basicAuth)
+
+			var header, claims = verifyJWT(t, request.PostForm.Get("client_assertion"), key.publicKey)
+			assert.Equal(t, algorithm, header["alg"])
+			assert.Equal(t, "key-1", header["kid"])
+			assert.Equal(t, "clientID", claims["iss"])
+			assert.Equal(t, "clientID", claims["sub"])
+			assert.Equal(t, server.URL, claims["aud"])
+			assert.NotEmpty(t, claims["jti"])
+		})
+	}
+	t.Run("Invalid key file", func(t *testing.T) {
+		var _, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL, PrivateKeyFile: filepath.Join(dir, "missing.key")})
+		assert.NotNil(t, err)
+
+		var invalidFile = filepath.Join(dir, "invalid.key")
+		writeFile(t, invalidFile, []byte("not a key"), time.Now())
+		_, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL, PrivateKeyFile: invalidFile})
+		assert.NotNil(t, err)
+
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		assert.Nil(t, err)
+		der, err := x509.MarshalECPrivateKey(p384Key)
+		assert.Nil(t, err)
+		writeFile(t, invalidFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), time.Now())
+		_, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenURL: server.URL, PrivateKeyFile: invalidFile})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFileTokenSource(t *testing.T) {
+	var tokenFile = filepath.Join(t.TempDir(), "token")
+	var now = time.Now()
+
+	t.Run("Missing file", func(t *testing.T) {
+		var _, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenFile: tokenFile})
+		assert.NotNil(t, err)
+	})
+	t.Run("Empty file", func(t *testing.T) {
+		writeFile(t, tokenFile, []byte("\n"), now)
+		var _, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenFile: tokenFile})
+		assert.NotNil(t, err)
+	})
+	t.Run("Token reloaded", func(t *testing.T) {
+		writeFile(t, tokenFile, []byte("first-token\n"), now)
+		var tp, err = NewTokenProviderWithConfig(TokenProviderConfig{TokenFile: tokenFile, Extensions: map[string]string{"a": "b"}})
+		assert.Nil(t, err)
+		token, err := tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "first-token", token.Token)
+		assert.Equal(t, map[string]string{"a": "b"}, token.Extensions)
+
+		writeFile(t, tokenFile, []byte("second-token"), now.Add(time.Minute))
+		token, err = tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "second-token", token.Token)
+
+		// An invalid update keeps the last valid token
+		writeFile(t, tokenFile, []byte(""), now.Add(2*time.Minute))
+		token, err = tp.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, "second-token", token.Token)
+	})
+}
@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/IBM/sarama (interfaces: ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer)
+// Source: github.com/IBM/sarama (interfaces: ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer,AsyncProducer,Consumer,PartitionConsumer,Client)
 //
 // Generated by this command:
 //
-//	mockgen --build_flags=--mod=mod -destination=./mock/sarama.go -package=mock -mock_names=ConsumerGroup=ConsumerGroup,ConsumerGroupSession=ConsumerGroupSession,ConsumerGroupClaim=ConsumerGroupClaim,SyncProducer=SyncProducer github.com/IBM/sarama ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer
+//	mockgen --build_flags=--mod=mod -destination=./mock/sarama.go -package=mock -mock_names=ConsumerGroup=ConsumerGroup,ConsumerGroupSession=ConsumerGroupSession,ConsumerGroupClaim=ConsumerGroupClaim,SyncProducer=SyncProducer,AsyncProducer=AsyncProducer,Consumer=Consumer,PartitionConsumer=PartitionConsumer,Client=Client github.com/IBM/sarama ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer,AsyncProducer,Consumer,PartitionConsumer,Client
 //
 
 // Package mock is a generated GoMock package.
@@ -518,3 +518,862 @@ func (mr *SyncProducerMockRecorder) TxnStatus() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TxnStatus", reflect.TypeOf((*SyncProducer)(nil).TxnStatus))
 }
+
+// AsyncProducer is a mock of AsyncProducer interface.
+type AsyncProducer struct {
+	ctrl     *gomock.Controller
+	recorder *AsyncProducerMockRecorder
+	isgomock struct{}
+}
+
+// AsyncProducerMockRecorder is the mock recorder for AsyncProducer.
+type AsyncProducerMockRecorder struct {
+	mock *AsyncProducer
+}
+
+// NewAsyncProducer creates a new mock instance.
+func NewAsyncProducer(ctrl *gomock.Controller) *AsyncProducer {
+	mock := &AsyncProducer{ctrl: ctrl}
+	mock.recorder = &AsyncProducerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *AsyncProducer) EXPECT() *AsyncProducerMockRecorder {
+	return m.recorder
+}
+
+// AbortTxn mocks base method.
+func (m *AsyncProducer) AbortTxn() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AbortTxn")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AbortTxn indicates an expected call of AbortTxn.
+func (mr *AsyncProducerMockRecorder) AbortTxn() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AbortTxn", reflect.TypeOf((*AsyncProducer)(nil).AbortTxn))
+}
+
+// AddMessageToTxn mocks base method.
+func (m *AsyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddMessageToTxn", msg, groupId, metadata)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddMessageToTxn indicates an expected call of AddMessageToTxn.
+func (mr *AsyncProducerMockRecorder) AddMessageToTxn(msg, groupId, metadata any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMessageToTxn", reflect.TypeOf((*AsyncProducer)(nil).AddMessageToTxn), msg, groupId, metadata)
+}
+
+// AddOffsetsToTxn mocks base method.
+func (m *AsyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOffsetsToTxn", offsets, groupId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOffsetsToTxn indicates an expected call of AddOffsetsToTxn.
+func (mr *AsyncProducerMockRecorder) AddOffsetsToTxn(offsets, groupId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOffsetsToTxn", reflect.TypeOf((*AsyncProducer)(nil).AddOffsetsToTxn), offsets, groupId)
+}
+
+// AsyncClose mocks base method.
+func (m *AsyncProducer) AsyncClose() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AsyncClose")
+}
+
+// AsyncClose indicates an expected call of AsyncClose.
+func (mr *AsyncProducerMockRecorder) AsyncClose() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AsyncClose", reflect.TypeOf((*AsyncProducer)(nil).AsyncClose))
+}
+
+// BeginTxn mocks base method.
+func (m *AsyncProducer) BeginTxn() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BeginTxn")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BeginTxn indicates an expected call of BeginTxn.
+func (mr *AsyncProducerMockRecorder) BeginTxn() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTxn", reflect.TypeOf((*AsyncProducer)(nil).BeginTxn))
+}
+
+// Close mocks base method.
+func (m *AsyncProducer) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *AsyncProducerMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*AsyncProducer)(nil).Close))
+}
+
+// CommitTxn mocks base method.
+func (m *AsyncProducer) CommitTxn() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitTxn")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CommitTxn indicates an expected call of CommitTxn.
+func (mr *AsyncProducerMockRecorder) CommitTxn() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitTxn", reflect.TypeOf((*AsyncProducer)(nil).CommitTxn))
+}
+
+// Errors mocks base method.
+func (m *AsyncProducer) Errors() <-chan *sarama.ProducerError {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Errors")
+	ret0, _ := ret[0].(<-chan *sarama.ProducerError)
+	return ret0
+}
+
+// Errors indicates an expected call of Errors.
+func (mr *AsyncProducerMockRecorder) Errors() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Errors", reflect.TypeOf((*AsyncProducer)(nil).Errors))
+}
+
+// Input mocks base method.
+func (m *AsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Input")
+	ret0, _ := ret[0].(chan<- *sarama.ProducerMessage)
+	return ret0
+}
+
+// Input indicates an expected call of Input.
+func (mr *AsyncProducerMockRecorder) Input() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Input", reflect.TypeOf((*AsyncProducer)(nil).Input))
+}
+
+// IsTransactional mocks base method.
+func (m *AsyncProducer) IsTransactional() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTransactional")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsTransactional indicates an expected call of IsTransactional.
+func (mr *AsyncProducerMockRecorder) IsTransactional() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTransactional", reflect.TypeOf((*AsyncProducer)(nil).IsTransactional))
+}
+
+// Successes mocks base method.
+func (m *AsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Successes")
+	ret0, _ := ret[0].(<-chan *sarama.ProducerMessage)
+	return ret0
+}
+
+// Successes indicates an expected call of Successes.
+func (mr *AsyncProducerMockRecorder) Successes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Successes", reflect.TypeOf((*AsyncProducer)(nil).Successes))
+}
+
+// TxnStatus mocks base method.
+func (m *AsyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TxnStatus")
+	ret0, _ := ret[0].(sarama.ProducerTxnStatusFlag)
+	return ret0
+}
+
+// TxnStatus indicates an expected call of TxnStatus.
+func (mr *AsyncProducerMockRecorder) TxnStatus() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TxnStatus", reflect.TypeOf((*AsyncProducer)(nil).TxnStatus))
+}
+
+// Consumer is a mock of Consumer interface.
+type Consumer struct {
+	ctrl     *gomock.Controller
+	recorder *ConsumerMockRecorder
+	isgomock struct{}
+}
+
+// ConsumerMockRecorder is the mock recorder for Consumer.
+type ConsumerMockRecorder struct {
+	mock *Consumer
+}
+
+// NewConsumer creates a new mock instance.
+func NewConsumer(ctrl *gomock.Controller) *Consumer {
+	mock := &Consumer{ctrl: ctrl}
+	mock.recorder = &ConsumerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Consumer) EXPECT() *ConsumerMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *Consumer) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *ConsumerMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*Consumer)(nil).Close))
+}
+
+// ConsumePartition mocks base method.
+func (m *Consumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumePartition", topic, partition, offset)
+	ret0, _ := ret[0].(sarama.PartitionConsumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumePartition indicates an expected call of ConsumePartition.
+func (mr *ConsumerMockRecorder) ConsumePartition(topic, partition, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumePartition", reflect.TypeOf((*Consumer)(nil).ConsumePartition), topic, partition, offset)
+}
+
+// HighWaterMarks mocks base method.
+func (m *Consumer) HighWaterMarks() map[string]map[int32]int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HighWaterMarks")
+	ret0, _ := ret[0].(map[string]map[int32]int64)
+	return ret0
+}
+
+// HighWaterMarks indicates an expected call of HighWaterMarks.
+func (mr *ConsumerMockRecorder) HighWaterMarks() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HighWaterMarks", reflect.TypeOf((*Consumer)(nil).HighWaterMarks))
+}
+
+// Partitions mocks base method.
+func (m *Consumer) Partitions(topic string) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Partitions", topic)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Partitions indicates an expected call of Partitions.
+func (mr *ConsumerMockRecorder) Partitions(topic any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Partitions", reflect.TypeOf((*Consumer)(nil).Partitions), topic)
+}
+
+// Pause mocks base method.
+func (m *Consumer) Pause(topicPartitions map[string][]int32) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Pause", topicPartitions)
+}
+
+// Pause indicates an expected call of Pause.
+func (mr *ConsumerMockRecorder) Pause(topicPartitions any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*Consumer)(nil).Pause), topicPartitions)
+}
+
+// PauseAll mocks base method.
+func (m *Consumer) PauseAll() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PauseAll")
+}
+
+// PauseAll indicates an expected call of PauseAll.
+func (mr *ConsumerMockRecorder) PauseAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseAll", reflect.TypeOf((*Consumer)(nil).PauseAll))
+}
+
+// Resume mocks base method.
+func (m *Consumer) Resume(topicPartitions map[string][]int32) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Resume", topicPartitions)
+}
+
+// Resume indicates an expected call of Resume.
+func (mr *ConsumerMockRecorder) Resume(topicPartitions any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resume", reflect.TypeOf((*Consumer)(nil).Resume), topicPartitions)
+}
+
+// ResumeAll mocks base method.
+func (m *Consumer) ResumeAll() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ResumeAll")
+}
+
+// ResumeAll indicates an expected call of ResumeAll.
+func (mr *ConsumerMockRecorder) ResumeAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeAll", reflect.TypeOf((*Consumer)(nil).ResumeAll))
+}
+
+// Topics mocks base method.
+func (m *Consumer) Topics() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Topics")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Topics indicates an expected call of Topics.
+func (mr *ConsumerMockRecorder) Topics() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Topics", reflect.TypeOf((*Consumer)(nil).Topics))
+}
+
+// PartitionConsumer is a mock of PartitionConsumer interface.
+type PartitionConsumer struct {
+	ctrl     *gomock.Controller
+	recorder *PartitionConsumerMockRecorder
+	isgomock struct{}
+}
+
+// PartitionConsumerMockRecorder is the mock recorder for PartitionConsumer.
+type PartitionConsumerMockRecorder struct {
+	mock *PartitionConsumer
+}
+
+// NewPartitionConsumer creates a new mock instance.
+func NewPartitionConsumer(ctrl *gomock.Controller) *PartitionConsumer {
+	mock := &PartitionConsumer{ctrl: ctrl}
+	mock.recorder = &PartitionConsumerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *PartitionConsumer) EXPECT() *PartitionConsumerMockRecorder {
+	return m.recorder
+}
+
+// AsyncClose mocks base method.
+func (m *PartitionConsumer) AsyncClose() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AsyncClose")
+}
+
+// AsyncClose indicates an expected call of AsyncClose.
+func (mr *PartitionConsumerMockRecorder) AsyncClose() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AsyncClose", reflect.TypeOf((*PartitionConsumer)(nil).AsyncClose))
+}
+
+// Close mocks base method.
+func (m *PartitionConsumer) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *PartitionConsumerMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*PartitionConsumer)(nil).Close))
+}
+
+// Errors mocks base method.
+func (m *PartitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Errors")
+	ret0, _ := ret[0].(<-chan *sarama.ConsumerError)
+	return ret0
+}
+
+// Errors indicates an expected call of Errors.
+func (mr *PartitionConsumerMockRecorder) Errors() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Errors", reflect.TypeOf((*PartitionConsumer)(nil).Errors))
+}
+
+// HighWaterMarkOffset mocks base method.
+func (m *PartitionConsumer) HighWaterMarkOffset() int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HighWaterMarkOffset")
+	ret0, _ := ret[0].(int64)
+	return ret0
+}
+
+// HighWaterMarkOffset indicates an expected call of HighWaterMarkOffset.
+func (mr *PartitionConsumerMockRecorder) HighWaterMarkOffset() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HighWaterMarkOffset", reflect.TypeOf((*PartitionConsumer)(nil).HighWaterMarkOffset))
+}
+
+// IsPaused mocks base method.
+func (m *PartitionConsumer) IsPaused() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsPaused")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsPaused indicates an expected call of IsPaused.
+func (mr *PartitionConsumerMockRecorder) IsPaused() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPaused", reflect.TypeOf((*PartitionConsumer)(nil).IsPaused))
+}
+
+// Messages mocks base method.
+func (m *PartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Messages")
+	ret0, _ := ret[0].(<-chan *sarama.ConsumerMessage)
+	return ret0
+}
+
+// Messages indicates an expected call of Messages.
+func (mr *PartitionConsumerMockRecorder) Messages() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Messages", reflect.TypeOf((*PartitionConsumer)(nil).Messages))
+}
+
+// Pause mocks base method.
+func (m *PartitionConsumer) Pause() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Pause")
+}
+
+// Pause indicates an expected call of Pause.
+func (mr *PartitionConsumerMockRecorder) Pause() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*PartitionConsumer)(nil).Pause))
+}
+
+// Resume mocks base method.
+func (m *PartitionConsumer) Resume() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Resume")
+}
+
+// Resume indicates an expected call of Resume.
+func (mr *PartitionConsumerMockRecorder) Resume() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resume", reflect.TypeOf((*PartitionConsumer)(nil).Resume))
+}
+
+// Client is a mock of Client interface.
+type Client struct {
+	ctrl     *gomock.Controller
+	recorder *ClientMockRecorder
+	isgomock struct{}
+}
+
+// ClientMockRecorder is the mock recorder for Client.
+type ClientMockRecorder struct {
+	mock *Client
+}
+
+// NewClient creates a new mock instance.
+func NewClient(ctrl *gomock.Controller) *Client {
+	mock := &Client{ctrl: ctrl}
+	mock.recorder = &ClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Client) EXPECT() *ClientMockRecorder {
+	return m.recorder
+}
+
+// Broker mocks base method.
+func (m *Client) Broker(brokerID int32) (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Broker", brokerID)
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Broker indicates an expected call of Broker.
+func (mr *ClientMockRecorder) Broker(brokerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Broker", reflect.TypeOf((*Client)(nil).Broker), brokerID)
+}
+
+// Brokers mocks base method.
+func (m *Client) Brokers() []*sarama.Broker {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Brokers")
+	ret0, _ := ret[0].([]*sarama.Broker)
+	return ret0
+}
+
+// Brokers indicates an expected call of Brokers.
+func (mr *ClientMockRecorder) Brokers() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Brokers", reflect.TypeOf((*Client)(nil).Brokers))
+}
+
+// Close mocks base method.
+func (m *Client) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *ClientMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*Client)(nil).Close))
+}
+
+// Closed mocks base method.
+func (m *Client) Closed() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Closed")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Closed indicates an expected call of Closed.
+func (mr *ClientMockRecorder) Closed() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Closed", reflect.TypeOf((*Client)(nil).Closed))
+}
+
+// Config mocks base method.
+func (m *Client) Config() *sarama.Config {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Config")
+	ret0, _ := ret[0].(*sarama.Config)
+	return ret0
+}
+
+// Config indicates an expected call of Config.
+func (mr *ClientMockRecorder) Config() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Config", reflect.TypeOf((*Client)(nil).Config))
+}
+
+// Controller mocks base method.
+func (m *Client) Controller() (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Controller")
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Controller indicates an expected call of Controller.
+func (mr *ClientMockRecorder) Controller() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Controller", reflect.TypeOf((*Client)(nil).Controller))
+}
+
+// Coordinator mocks base method.
+func (m *Client) Coordinator(consumerGroup string) (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Coordinator", consumerGroup)
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Coordinator indicates an expected call of Coordinator.
+func (mr *ClientMockRecorder) Coordinator(consumerGroup any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Coordinator", reflect.TypeOf((*Client)(nil).Coordinator), consumerGroup)
+}
+
+// GetOffset mocks base method.
+func (m *Client) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOffset", topic, partitionID, time)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOffset indicates an expected call of GetOffset.
+func (mr *ClientMockRecorder) GetOffset(topic, partitionID, time any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOffset", reflect.TypeOf((*Client)(nil).GetOffset), topic, partitionID, time)
+}
+
+// InSyncReplicas mocks base method.
+func (m *Client) InSyncReplicas(topic string, partitionID int32) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InSyncReplicas", topic, partitionID)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InSyncReplicas indicates an expected call of InSyncReplicas.
+func (mr *ClientMockRecorder) InSyncReplicas(topic, partitionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InSyncReplicas", reflect.TypeOf((*Client)(nil).InSyncReplicas), topic, partitionID)
+}
+
+// InitProducerID mocks base method.
+func (m *Client) InitProducerID() (*sarama.InitProducerIDResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InitProducerID")
+	ret0, _ := ret[0].(*sarama.InitProducerIDResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InitProducerID indicates an expected call of InitProducerID.
+func (mr *ClientMockRecorder) InitProducerID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitProducerID", reflect.TypeOf((*Client)(nil).InitProducerID))
+}
+
+// Leader mocks base method.
+func (m *Client) Leader(topic string, partitionID int32) (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Leader", topic, partitionID)
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Leader indicates an expected call of Leader.
+func (mr *ClientMockRecorder) Leader(topic, partitionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Leader", reflect.TypeOf((*Client)(nil).Leader), topic, partitionID)
+}
+
+// LeaderAndEpoch mocks base method.
+func (m *Client) LeaderAndEpoch(topic string, partitionID int32) (*sarama.Broker, int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaderAndEpoch", topic, partitionID)
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(int32)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// LeaderAndEpoch indicates an expected call of LeaderAndEpoch.
+func (mr *ClientMockRecorder) LeaderAndEpoch(topic, partitionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaderAndEpoch", reflect.TypeOf((*Client)(nil).LeaderAndEpoch), topic, partitionID)
+}
+
+// LeastLoadedBroker mocks base method.
+func (m *Client) LeastLoadedBroker() *sarama.Broker {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeastLoadedBroker")
+	ret0, _ := ret[0].(*sarama.Broker)
+	return ret0
+}
+
+// LeastLoadedBroker indicates an expected call of LeastLoadedBroker.
+func (mr *ClientMockRecorder) LeastLoadedBroker() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeastLoadedBroker", reflect.TypeOf((*Client)(nil).LeastLoadedBroker))
+}
+
+// OfflineReplicas mocks base method.
+func (m *Client) OfflineReplicas(topic string, partitionID int32) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OfflineReplicas", topic, partitionID)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OfflineReplicas indicates an expected call of OfflineReplicas.
+func (mr *ClientMockRecorder) OfflineReplicas(topic, partitionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OfflineReplicas", reflect.TypeOf((*Client)(nil).OfflineReplicas), topic, partitionID)
+}
+
+// PartitionNotReadable mocks base method.
+func (m *Client) PartitionNotReadable(topic string, partition int32) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PartitionNotReadable", topic, partition)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// PartitionNotReadable indicates an expected call of PartitionNotReadable.
+func (mr *ClientMockRecorder) PartitionNotReadable(topic, partition any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PartitionNotReadable", reflect.TypeOf((*Client)(nil).PartitionNotReadable), topic, partition)
+}
+
+// Partitions mocks base method.
+func (m *Client) Partitions(topic string) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Partitions", topic)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Partitions indicates an expected call of Partitions.
+func (mr *ClientMockRecorder) Partitions(topic any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Partitions", reflect.TypeOf((*Client)(nil).Partitions), topic)
+}
+
+// RefreshBrokers mocks base method.
+func (m *Client) RefreshBrokers(addrs []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshBrokers", addrs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RefreshBrokers indicates an expected call of RefreshBrokers.
+func (mr *ClientMockRecorder) RefreshBrokers(addrs any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshBrokers", reflect.TypeOf((*Client)(nil).RefreshBrokers), addrs)
+}
+
+// RefreshController mocks base method.
+func (m *Client) RefreshController() (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshController")
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshController indicates an expected call of RefreshController.
+func (mr *ClientMockRecorder) RefreshController() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshController", reflect.TypeOf((*Client)(nil).RefreshController))
+}
+
+// RefreshCoordinator mocks base method.
+func (m *Client) RefreshCoordinator(consumerGroup string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshCoordinator", consumerGroup)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RefreshCoordinator indicates an expected call of RefreshCoordinator.
+func (mr *ClientMockRecorder) RefreshCoordinator(consumerGroup any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshCoordinator", reflect.TypeOf((*Client)(nil).RefreshCoordinator), consumerGroup)
+}
+
+// RefreshMetadata mocks base method.
+func (m *Client) RefreshMetadata(topics ...string) error {
+	m.ctrl.T.Helper()
+	varargs := []any{}
+	for _, a := range topics {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RefreshMetadata", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RefreshMetadata indicates an expected call of RefreshMetadata.
+func (mr *ClientMockRecorder) RefreshMetadata(topics ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshMetadata", reflect.TypeOf((*Client)(nil).RefreshMetadata), topics...)
+}
+
+// RefreshTransactionCoordinator mocks base method.
+func (m *Client) RefreshTransactionCoordinator(transactionID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshTransactionCoordinator", transactionID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RefreshTransactionCoordinator indicates an expected call of RefreshTransactionCoordinator.
+func (mr *ClientMockRecorder) RefreshTransactionCoordinator(transactionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshTransactionCoordinator", reflect.TypeOf((*Client)(nil).RefreshTransactionCoordinator), transactionID)
+}
+
+// Replicas mocks base method.
+func (m *Client) Replicas(topic string, partitionID int32) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Replicas", topic, partitionID)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Replicas indicates an expected call of Replicas.
+func (mr *ClientMockRecorder) Replicas(topic, partitionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Replicas", reflect.TypeOf((*Client)(nil).Replicas), topic, partitionID)
+}
+
+// Topics mocks base method.
+func (m *Client) Topics() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Topics")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Topics indicates an expected call of Topics.
+func (mr *ClientMockRecorder) Topics() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Topics", reflect.TypeOf((*Client)(nil).Topics))
+}
+
+// TransactionCoordinator mocks base method.
+func (m *Client) TransactionCoordinator(transactionID string) (*sarama.Broker, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransactionCoordinator", transactionID)
+	ret0, _ := ret[0].(*sarama.Broker)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransactionCoordinator indicates an expected call of TransactionCoordinator.
+func (mr *ClientMockRecorder) TransactionCoordinator(transactionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransactionCoordinator", reflect.TypeOf((*Client)(nil).TransactionCoordinator), transactionID)
+}
+
+// WritablePartitions mocks base method.
+func (m *Client) WritablePartitions(topic string) ([]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WritablePartitions", topic)
+	ret0, _ := ret[0].([]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WritablePartitions indicates an expected call of WritablePartitions.
+func (mr *ClientMockRecorder) WritablePartitions(topic any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WritablePartitions", reflect.TypeOf((*Client)(nil).WritablePartitions), topic)
+}
@@ -1,4 +1,4 @@
 package kafkauniverse
 
 //go:generate mockgen --build_flags=--mod=mod -destination=./mock/universe.go -package=mock -mock_names=Logger=Logger github.com/cloudtrust/kafka-client Logger
-//go:generate mockgen --build_flags=--mod=mod -destination=./mock/sarama.go -package=mock -mock_names=ConsumerGroup=ConsumerGroup,ConsumerGroupSession=ConsumerGroupSession,ConsumerGroupClaim=ConsumerGroupClaim,SyncProducer=SyncProducer github.com/IBM/sarama ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer
+//go:generate mockgen --build_flags=--mod=mod -destination=./mock/sarama.go -package=mock -mock_names=ConsumerGroup=ConsumerGroup,ConsumerGroupSession=ConsumerGroupSession,ConsumerGroupClaim=ConsumerGroupClaim,SyncProducer=SyncProducer,AsyncProducer=AsyncProducer,Consumer=Consumer,PartitionConsumer=PartitionConsumer,Client=Client github.com/IBM/sarama ConsumerGroup,ConsumerGroupSession,ConsumerGroupClaim,SyncProducer,AsyncProducer,Consumer,PartitionConsumer,Client
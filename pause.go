@@ -0,0 +1,113 @@
+package kafkauniverse
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/IBM/sarama"
+)
+
+// Pause stops fetching the messages of the consumer and of its retry stages until Resume is called. The consumer stays a member of its
+// group, so no rebalance occurs. The messages already fetched are held until the consumer is resumed
+func (c *consumer) Pause() {
+	c.withStages(func(c *consumer) {
+		c.pauseMutex.Lock()
+		defer c.pauseMutex.Unlock()
+		c.paused = true
+		c.pause(c.claimed)
+	})
+	c.logger.Info(context.Background(), "msg", "Consumer paused", "consumer", c.id, "topic", c.topic)
+}
+
+// PausePartitions stops fetching the messages of the given partitions of the consumer topic until Resume is called
+func (c *consumer) PausePartitions(partitions ...int32) {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+	for _, partition := range partitions {
+		c.pausedPartitions[partition] = true
+	}
+	c.pause(partitions)
+	c.logger.Info(context.Background(), "msg", "Consumer partitions paused", "consumer", c.id, "topic", c.topic, "partitions", partitions)
+}
+
+// Resume resumes fetching the messages of the consumer and of its retry stages, including the partitions paused by PausePartitions
+func (c *consumer) Resume() {
+	c.withStages(func(c *consumer) {
+		c.pauseMutex.Lock()
+		defer c.pauseMutex.Unlock()
+		var partitions = slices.AppendSeq(slices.Clone(c.claimed), maps.Keys(c.pausedPartitions))
+		c.paused = false
+		clear(c.pausedPartitions)
+		close(c.resumed)
+		c.resumed = make(chan struct{})
+		if c.consumerGroup != nil && len(partitions) > 0 {
+			c.consumerGroup.Resume(map[string][]int32{c.topic: partitions})
+		}
+	})
+	c.logger.Info(context.Background(), "msg", "Consumer resumed", "consumer", c.id, "topic", c.topic)
+}
+
+// IsPaused tells if the consumer has been paused, entirely or for some partitions
+func (c *consumer) IsPaused() bool {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+	return c.paused || len(c.pausedPartitions) > 0
+}
+
+// isPartitionPaused tells if the given partition has been paused. The caller must hold pauseMutex
+func (c *consumer) isPartitionPaused(partition int32) bool {
+	return c.paused || c.pausedPartitions[partition]
+}
+
+// waitWhilePaused blocks while the given partition is paused, so that the messages fetched before the pause are not handled. It returns
+// false if the given context (of the session) ends meanwhile
+func (c *consumer) waitWhilePaused(ctx context.Context, partition int32) bool {
+	for {
+		c.pauseMutex.Lock()
+		var paused, resumed = c.isPartitionPaused(partition), c.resumed
+		c.pauseMutex.Unlock()
+		if !paused {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-resumed:
+		}
+	}
+}
+
+// pause pauses the given partitions of the consumer topic if they are consumed. The caller must hold pauseMutex
+func (c *consumer) pause(partitions []int32) {
+	if c.consumerGroup != nil && len(partitions) > 0 {
+		c.consumerGroup.Pause(map[string][]int32{c.topic: partitions})
+	}
+}
+
+// claimPartitions records the partitions claimed by a new session, so that they can be paused or resumed
+func (c *consumer) claimPartitions(partitions []int32) {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+	c.claimed = partitions
+}
+
+// applyPause pauses a newly claimed partition if it has been paused before. Sarama forgets the paused partitions when a session ends
+func (c *consumer) applyPause(claim sarama.ConsumerGroupClaim) {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+	if c.paused || len(c.pausedPartitions) > 0 {
+		if partition := claim.Partition(); c.isPartitionPaused(partition) {
+			c.pause([]int32{partition})
+		}
+	}
+}
+
+// resumeAfterDelay resumes a partition paused during a consumption delay, unless it has been paused in the meantime
+func (c *consumer) resumeAfterDelay(partition int32) {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+	if !c.isPartitionPaused(partition) {
+		c.consumerGroup.Resume(map[string][]int32{c.topic: {partition}})
+	}
+}
@@ -0,0 +1,176 @@
+package kafkauniverse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPauseConsumer(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var mockConsumerGroup = mock.NewConsumerGroup(mockCtrl)
+	var mockStageConsumerGroup = mock.NewConsumerGroup(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+
+	var aConsumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+	var stage = aConsumer.addRetryStage(&producer{id: "retry", topic: new("topic-retry")}, time.Minute)
+
+	t.Run("Not initialized", func(t *testing.T) {
+		aConsumer.Pause()
+		assert.True(t, aConsumer.IsPaused())
+		assert.True(t, stage.IsPaused())
+		aConsumer.Resume()
+		assert.False(t, aConsumer.IsPaused())
+		assert.False(t, stage.IsPaused())
+	})
+
+	aConsumer.consumerGroup = mockConsumerGroup
+	aConsumer.claimPartitions([]int32{0, 1})
+	stage.consumerGroup = mockStageConsumerGroup
+	stage.claimPartitions([]int32{4})
+
+	t.Run("Pause and resume with stages", func(t *testing.T) {
+		mockConsumerGroup.EXPECT().Pause(map[string][]int32{"topic": {0, 1}})
+		mockStageConsumerGroup.EXPECT().Pause(map[string][]int32{"topic-retry": {4}})
+		aConsumer.Pause()
+		assert.True(t, aConsumer.IsPaused())
+
+		// Paused partitions are paused again when they are claimed by a new session
+		mockConsumerGroupClaim.EXPECT().Partition().Return(int32(1))
+		mockConsumerGroup.EXPECT().Pause(map[string][]int32{"topic": {1}})
+		aConsumer.applyPause(mockConsumerGroupClaim)
+
+		mockConsumerGroup.EXPECT().Resume(map[string][]int32{"topic": {0, 1}})
+		mockStageConsumerGroup.EXPECT().Resume(map[string][]int32{"topic-retry": {4}})
+		aConsumer.Resume()
+		assert.False(t, aConsumer.IsPaused())
+
+		// Nothing to pause
+		aConsumer.applyPause(mockConsumerGroupClaim)
+	})
+	t.Run("Pause partitions", func(t *testing.T) {
+		mockConsumerGroup.EXPECT().Pause(map[string][]int32{"topic": {2}})
+		aConsumer.PausePartitions(2)
+		assert.True(t, aConsumer.IsPaused())
+		assert.False(t, stage.IsPaused())
+
+		mockConsumerGroupClaim.EXPECT().Partition().Return(int32(0))
+		aConsumer.applyPause(mockConsumerGroupClaim)
+		mockConsumerGroupClaim.EXPECT().Partition().Return(int32(2))
+		mockConsumerGroup.EXPECT().Pause(map[string][]int32{"topic": {2}})
+		aConsumer.applyPause(mockConsumerGroupClaim)
+
+		// A partition paused by a consumption delay is not resumed when it is paused by the user
+		aConsumer.resumeAfterDelay(2)
+		mockConsumerGroup.EXPECT().Resume(map[string][]int32{"topic": {0}})
+		aConsumer.resumeAfterDelay(0)
+
+		mockConsumerGroup.EXPECT().Resume(map[string][]int32{"topic": {0, 1, 2}})
+		mockStageConsumerGroup.EXPECT().Resume(map[string][]int32{"topic-retry": {4}})
+		aConsumer.Resume()
+		assert.False(t, aConsumer.IsPaused())
+	})
+}
+
+func TestConsumeClaimWhilePaused(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Partition().Return(int32(0)).AnyTimes()
+
+	var newPausedConsumer = func(configure func(*KafkaConsumerRepresentation), handled chan struct{}) *consumer {
+		var consumerConf = createDefaultConsumerConfiguration()
+		configure(&consumerConf)
+		var aConsumer = newConsumer(cluster, consumerConf, logger)
+		if consumerConf.Batch != nil {
+			aConsumer.SetBatchHandler(func(context.Context, []KafkaMessage) error {
+				close(handled)
+				return nil
+			})
+		} else {
+			aConsumer.SetHandler(func(context.Context, KafkaMessage) error {
+				close(handled)
+				return nil
+			})
+		}
+		aConsumer.Pause()
+		return aConsumer
+	}
+	var newMessages = func() chan *sarama.ConsumerMessage {
+		// Message fetched before the consumer was paused
+		var messages = make(chan *sarama.ConsumerMessage, 1)
+		messages <- &sarama.ConsumerMessage{Topic: "topic", Partition: 0, Offset: 1, Value: []byte("message")}
+		close(messages)
+		return messages
+	}
+	var modes = map[string]func(*KafkaConsumerRepresentation){
+		"Sequential": func(*KafkaConsumerRepresentation) {},
+		"Concurrent": func(conf *KafkaConsumerRepresentation) { conf.Workers = new(2) },
+		"Batch": func(conf *KafkaConsumerRepresentation) {
+			conf.Batch = &KafkaBatchRepresentation{MaxSize: new(10), Linger: new(time.Hour)}
+		},
+	}
+
+	for name, configure := range modes {
+		t.Run(name+": handled once resumed", func(t *testing.T) {
+			var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+			var handled = make(chan struct{})
+			var aConsumer = newPausedConsumer(configure, handled)
+			mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+			mockConsumerGroupSession.EXPECT().MarkMessage(gomock.Any(), "").MaxTimes(1)
+			mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(2), "").MaxTimes(1)
+			mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages())
+
+			var done = make(chan error)
+			go func() { done <- aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim) }()
+			select {
+			case <-handled:
+				assert.Fail(t, "message handled while the consumer is paused")
+			case <-time.After(50 * time.Millisecond):
+			}
+			aConsumer.Resume()
+			<-handled
+			assert.Nil(t, <-done)
+		})
+		t.Run(name+": session ends while paused", func(t *testing.T) {
+			var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+			var handled = make(chan struct{})
+			var aConsumer = newPausedConsumer(configure, handled)
+			var ctx, cancel = context.WithCancel(context.TODO())
+			mockConsumerGroupSession.EXPECT().Context().Return(ctx).AnyTimes()
+			mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages())
+
+			var done = make(chan error)
+			go func() { done <- aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim) }()
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			assert.Nil(t, <-done)
+			select {
+			case <-handled:
+				assert.Fail(t, "message handled while the consumer is paused")
+			default:
+			}
+		})
+	}
+}
@@ -2,18 +2,138 @@ package kafkauniverse
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/cloudtrust/kafka-client/misc"
+	"github.com/google/uuid"
 )
 
+const defaultProducerMaxInFlight = 256
+
 type Producer interface {
+	SendMessage(ctx context.Context, message Message) (RecordMetadata, error)
+	SendMessageAsync(ctx context.Context, message Message, callback DeliveryCallback) error
+	SendMessages(ctx context.Context, messages []Message) ([]RecordMetadata, error)
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	SendMessageBytes(content []byte) error
 	SendPartitionedMessageBytes(partitionKey string, content []byte) error
+	Flush(ctx context.Context) error
 	Close() error
 }
 
+// DeliveryCallback is invoked when a message sent asynchronously has been written or has definitely failed
+type DeliveryCallback func(metadata RecordMetadata, err error)
+
+// Message is a message sent by a producer
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+	// Partition forces the partition of the message. When nil, the partition is chosen according to the key
+	Partition *int32
+	// Timestamp of the message. When zero, the current time is used
+	Timestamp time.Time
+}
+
+// RecordMetadata describes where a message has been written
+type RecordMetadata struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ProducerError describes a message of a batch which can't be sent
+type ProducerError struct {
+	// Index of the message in the batch
+	Index   int
+	Message Message
+	Err     error
+}
+
+func (pe *ProducerError) Error() string {
+	return fmt.Sprintf("kafka: failed to send message #%d: %s", pe.Index, pe.Err)
+}
+
+func (pe *ProducerError) Unwrap() error {
+	return pe.Err
+}
+
+// ProducerErrors is returned when some messages of a batch can't be sent
+type ProducerErrors []*ProducerError
+
+func (pe ProducerErrors) Error() string {
+	return fmt.Sprintf("kafka: failed to send %d messages", len(pe))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the errors of the failed messages
+func (pe ProducerErrors) Unwrap() []error {
+	var errs = make([]error, len(pe))
+	for idx, producerError := range pe {
+		errs[idx] = producerError
+	}
+	return errs
+}
+
+// producerMetadata is set as metadata of the sent messages
+type producerMetadata struct {
+	// partition is the explicit partition of the message, if any
+	partition *int32
+	// delivered is invoked when an asynchronous producer gets the result of the message
+	delivered DeliveryCallback
+}
+
+// explicitPartitioner writes messages in their explicit partition if any and delegates to another partitioner otherwise
+type explicitPartitioner struct {
+	sarama.Partitioner
+}
+
+func newExplicitPartitioner(fallback sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &explicitPartitioner{Partitioner: fallback(topic)}
+	}
+}
+
+func (ep *explicitPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if partition := explicitPartition(message); partition != nil {
+		if *partition < 0 || *partition >= numPartitions {
+			return -1, sarama.ErrInvalidPartition
+		}
+		return *partition, nil
+	}
+	return ep.Partitioner.Partition(message, numPartitions)
+}
+
+func (ep *explicitPartitioner) RequiresConsistency() bool {
+	return ep.Partitioner.RequiresConsistency()
+}
+
+// MessageRequiresConsistency tells sarama to choose among all the partitions of the topic, and not only among the writable ones, so that
+// the messages with an explicit partition are written in this partition. Other messages follow the fallback partitioner
+func (ep *explicitPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if explicitPartition(message) != nil {
+		return true
+	}
+	if dynamic, ok := ep.Partitioner.(sarama.DynamicConsistencyPartitioner); ok {
+		return dynamic.MessageRequiresConsistency(message)
+	}
+	return ep.Partitioner.RequiresConsistency()
+}
+
+// explicitPartition gets the explicit partition of a message, or nil if it has none
+func explicitPartition(message *sarama.ProducerMessage) *int32 {
+	if metadata, ok := message.Metadata.(*producerMetadata); ok {
+		return metadata.partition
+	}
+	return nil
+}
+
 type producer struct {
 	initialized bool
 	cluster     *cluster
@@ -22,6 +142,19 @@ type producer struct {
 	topic       *string
 	producer    sarama.SyncProducer
 	logger      Logger
+	// Asynchronous mode
+	async         bool
+	maxInFlight   int
+	asyncProducer sarama.AsyncProducer
+	inFlight      chan struct{}
+	dispatched    chan struct{}
+	closeMutex    sync.RWMutex
+	closed        bool
+	// Transactional mode
+	transactionalID *string
+	txnMutex        sync.Mutex
+	// saramaTuning overrides the producer settings of the cluster
+	saramaTuning *KafkaSaramaProducerRepresentation
 }
 
 func newProducer(cluster *cluster, producerRep KafkaProducerRepresentation, logger Logger) *producer {
@@ -29,22 +162,75 @@ func newProducer(cluster *cluster, producerRep KafkaProducerRepresentation, logg
 	if !cluster.enabled || (producerRep.Enabled != nil && !*producerRep.Enabled) {
 		enabled = false
 	}
+	var maxInFlight = defaultProducerMaxInFlight
+	if producerRep.MaxInFlight != nil {
+		maxInFlight = *producerRep.MaxInFlight
+	}
+	// Each instance needs its own transactional id, otherwise instances fence each other: it can reference environment variables,
+	// like ${HOSTNAME}, or contain <UUID> which is replaced by a random UUID
+	var transactionalID = producerRep.TransactionalID
+	if transactionalID != nil {
+		var expanded = strings.Replace(os.ExpandEnv(*transactionalID), "<UUID>", uuid.New().String(), 1)
+		if expanded == "" {
+			logger.Warn(context.Background(), "msg", "Transactional id is empty once expanded: the template is used as is", "producer",
+				*producerRep.ID, "template", *transactionalID)
+		} else {
+			transactionalID = &expanded
+		}
+	}
 	return &producer{
-		initialized: false,
-		cluster:     cluster,
-		id:          *producerRep.ID,
-		enabled:     enabled,
-		topic:       producerRep.Topic,
-		logger:      logger,
+		initialized:     false,
+		cluster:         cluster,
+		id:              *producerRep.ID,
+		enabled:         enabled,
+		topic:           producerRep.Topic,
+		logger:          logger,
+		async:           producerRep.Async != nil && *producerRep.Async,
+		maxInFlight:     maxInFlight,
+		transactionalID: transactionalID,
+		saramaTuning:    producerRep.Sarama,
 	}
 }
 
-// Close closes all resources
+// Close closes all resources. In asynchronous mode, the messages already sent are flushed first
 func (p *producer) Close() error {
 	if !p.initialized || !p.enabled {
 		return nil
 	}
-	return p.producer.Close()
+	if !p.async {
+		return p.producer.Close()
+	}
+	p.closeMutex.Lock()
+	p.closed = true
+	p.closeMutex.Unlock()
+	if err := p.Flush(context.Background()); err != nil {
+		p.logger.Warn(context.Background(), "msg", "Failed to flush Kafka producer", "producer", p.id, "err", err)
+	}
+	var err = p.asyncProducer.Close()
+	<-p.dispatched
+	return err
+}
+
+// Flush waits until all the messages sent asynchronously are written or have definitely failed. It does nothing in synchronous mode
+func (p *producer) Flush(ctx context.Context) error {
+	if !p.async || !p.initialized || !p.enabled {
+		return nil
+	}
+	// All in-flight slots are available once every pending message has been delivered
+	var acquired = 0
+	defer func() {
+		for range acquired {
+			<-p.inFlight
+		}
+	}()
+	for ; acquired < cap(p.inFlight); acquired++ {
+		select {
+		case p.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
 }
 
 func (p *producer) initialize() error {
@@ -56,8 +242,18 @@ func (p *producer) initialize() error {
 		p.initialized = true
 		return nil
 	}
+	if p.async {
+		var asyncProducer, err = sarama.NewAsyncProducer(p.cluster.brokers, p.saramaConfig())
+		if err != nil {
+			p.logger.Error(context.Background(), "msg", "Failed to initialize Kafka producer", "err", err)
+			return err
+		}
+		p.startAsync(asyncProducer)
+		p.initialized = true
+		return nil
+	}
 	var err error
-	if p.producer, err = sarama.NewSyncProducer(p.cluster.brokers, p.cluster.saramaConfig); err != nil {
+	if p.producer, err = sarama.NewSyncProducer(p.cluster.brokers, p.saramaConfig()); err != nil {
 		p.logger.Error(context.Background(), "msg", "Failed to initialize Kafka producer", "err", err)
 		return err
 	}
@@ -65,22 +261,357 @@ func (p *producer) initialize() error {
 	return nil
 }
 
+// saramaConfig returns the configuration of the cluster, overridden by the tuning of the producer and completed with the settings
+// required by transactions when the producer is transactional. The configuration of the cluster is copied before being modified
+func (p *producer) saramaConfig() *sarama.Config {
+	if p.transactionalID == nil && p.saramaTuning == nil {
+		return p.cluster.saramaConfig
+	}
+	var config = *p.cluster.saramaConfig
+	applyProducerTuning(&config, p.saramaTuning)
+	if p.transactionalID == nil {
+		return &config
+	}
+	config.Producer.Transaction.ID = *p.transactionalID
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Net.MaxOpenRequests = 1
+	return &config
+}
+
+func (p *producer) startAsync(asyncProducer sarama.AsyncProducer) {
+	p.asyncProducer = asyncProducer
+	p.inFlight = make(chan struct{}, p.maxInFlight)
+	p.dispatched = make(chan struct{})
+	go p.dispatch(asyncProducer.Successes(), asyncProducer.Errors())
+}
+
+// dispatch notifies the results of the messages sent asynchronously until the asynchronous producer is closed
+func (p *producer) dispatch(successes <-chan *sarama.ProducerMessage, errs <-chan *sarama.ProducerError) {
+	defer close(p.dispatched)
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			p.delivered(msg, nil)
+		case producerErr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			p.delivered(producerErr.Msg, producerErr.Err)
+		}
+	}
+}
+
+func (p *producer) delivered(msg *sarama.ProducerMessage, err error) {
+	<-p.inFlight
+	var metadata = RecordMetadata{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	if producerMetadata, ok := msg.Metadata.(*producerMetadata); ok && producerMetadata.delivered != nil {
+		producerMetadata.delivered(metadata, err)
+	} else if err != nil {
+		p.logger.Warn(context.Background(), "msg", "Failed to send message", "producer", p.id, "err", err, "topic", msg.Topic)
+	}
+}
+
+// SendMessage sends a message in the producer topic and returns where it has been written. The context bounds the time spent waiting
+// for the acknowledgement of the message: when the context is done first, the message may still be written
+func (p *producer) SendMessage(ctx context.Context, message Message) (RecordMetadata, error) {
+	return p.send(ctx, newProducerMessage(message))
+}
+
+// SendMessageAsync sends a message in the producer topic without waiting for its acknowledgement. The callback, if any, is invoked
+// with the result of the message. The context bounds the time spent waiting for an in-flight slot. In synchronous mode, the message is
+// sent before SendMessageAsync returns
+func (p *producer) SendMessageAsync(ctx context.Context, message Message, callback DeliveryCallback) error {
+	var msg = newProducerMessage(message)
+	if !p.async || !p.enabled {
+		var metadata, err = p.send(ctx, msg)
+		if callback != nil {
+			callback(metadata, err)
+		}
+		return err
+	}
+	if !p.initialized {
+		return fmt.Errorf("failed to send message to uninitialized producer %s", p.id)
+	}
+	msg.Metadata.(*producerMetadata).delivered = callback
+	return p.enqueue(ctx, msg)
+}
+
+func newProducerMessage(message Message) *sarama.ProducerMessage {
+	var msg = &sarama.ProducerMessage{
+		Value:     sarama.ByteEncoder(message.Value),
+		Timestamp: message.Timestamp,
+		Metadata:  &producerMetadata{partition: message.Partition},
+	}
+	if message.Key != nil {
+		msg.Key = sarama.ByteEncoder(message.Key)
+	}
+	// Sort header names to send the headers in a predictable order
+	var names = make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(message.Headers[name])})
+	}
+	return msg
+}
+
 // SendMessageBytes sends a message in the producer topic
 func (p *producer) SendMessageBytes(content []byte) error {
-	if !p.enabled {
-		return nil
-	}
-	msg := &sarama.ProducerMessage{Topic: *p.topic, Value: sarama.StringEncoder(content)}
-	var _, _, err = p.producer.SendMessage(msg)
-	return err
+	return p.sendMessage(&sarama.ProducerMessage{Value: sarama.StringEncoder(content)})
 }
 
 // SendPartitionedMessageBytes sends a message in the producer topic
 func (p *producer) SendPartitionedMessageBytes(partitionKey string, content []byte) error {
+	return p.sendMessage(&sarama.ProducerMessage{Key: sarama.StringEncoder(partitionKey), Value: sarama.StringEncoder(content)})
+}
+
+// sendMessage sends a message in the producer topic, unless the message already targets another topic
+func (p *producer) sendMessage(msg *sarama.ProducerMessage) error {
+	var _, err = p.send(context.Background(), msg)
+	return err
+}
+
+// send sends a message in the producer topic, unless the message already targets another topic, and waits for its acknowledgement
+// until the context is done
+func (p *producer) send(ctx context.Context, msg *sarama.ProducerMessage) (RecordMetadata, error) {
 	if !p.enabled {
+		return RecordMetadata{}, nil
+	}
+	if !p.initialized {
+		return RecordMetadata{}, fmt.Errorf("failed to send message to uninitialized producer %s", p.id)
+	}
+	if msg.Topic == "" {
+		msg.Topic = *p.topic
+	}
+	if err := ctx.Err(); err != nil {
+		return RecordMetadata{}, err
+	}
+	if p.async {
+		return p.sendAndWait(ctx, msg)
+	}
+	var partition int32
+	var offset int64
+	var err = runWithContext(ctx, func() (err error) {
+		partition, offset, err = p.producer.SendMessage(msg)
+		return err
+	})
+	if err != nil {
+		return RecordMetadata{}, err
+	}
+	return RecordMetadata{Topic: msg.Topic, Partition: partition, Offset: offset}, nil
+}
+
+// SendMessages sends a batch of messages in the producer topic and returns where they have been written. When some messages can't
+// be sent, the returned error is a ProducerErrors listing them and the metadata of the messages which have been sent are still returned
+func (p *producer) SendMessages(ctx context.Context, messages []Message) ([]RecordMetadata, error) {
+	if !p.enabled || len(messages) == 0 {
+		return make([]RecordMetadata, len(messages)), nil
+	}
+	if !p.initialized {
+		return nil, fmt.Errorf("failed to send messages to uninitialized producer %s", p.id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var msgs = make([]*sarama.ProducerMessage, len(messages))
+	for idx, message := range messages {
+		msgs[idx] = newProducerMessage(message)
+		msgs[idx].Topic = *p.topic
+	}
+
+	var results []error
+	var err error
+	if p.async {
+		results, err = p.sendAllAndWait(ctx, msgs)
+	} else {
+		results, err = p.sendAll(ctx, msgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata = make([]RecordMetadata, len(msgs))
+	var producerErrors ProducerErrors
+	for idx, msg := range msgs {
+		if results[idx] != nil {
+			producerErrors = append(producerErrors, &ProducerError{Index: idx, Message: messages[idx], Err: results[idx]})
+		} else {
+			metadata[idx] = RecordMetadata{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+		}
+	}
+	if len(producerErrors) > 0 {
+		return metadata, producerErrors
+	}
+	return metadata, nil
+}
+
+// sendAll sends a batch of messages through the synchronous producer and returns the error of each message
+func (p *producer) sendAll(ctx context.Context, msgs []*sarama.ProducerMessage) ([]error, error) {
+	var err = runWithContext(ctx, func() error {
+		return p.producer.SendMessages(msgs)
+	})
+	var results = make([]error, len(msgs))
+	if err == nil {
+		return results, nil
+	}
+	var saramaErrors sarama.ProducerErrors
+	if !errors.As(err, &saramaErrors) {
+		return nil, err
+	}
+	var indexes = make(map[*sarama.ProducerMessage]int, len(msgs))
+	for idx, msg := range msgs {
+		indexes[msg] = idx
+	}
+	for _, saramaError := range saramaErrors {
+		if idx, ok := indexes[saramaError.Msg]; ok {
+			results[idx] = saramaError.Err
+		}
+	}
+	return results, nil
+}
+
+// sendAllAndWait sends a batch of messages through the asynchronous producer and waits for all their results until the context is done
+func (p *producer) sendAllAndWait(ctx context.Context, msgs []*sarama.ProducerMessage) ([]error, error) {
+	var results = make([]error, len(msgs))
+	var wg sync.WaitGroup
+	wg.Add(len(msgs))
+	for idx, msg := range msgs {
+		msg.Metadata.(*producerMetadata).delivered = func(_ RecordMetadata, err error) {
+			results[idx] = err
+			wg.Done()
+		}
+		if err := p.enqueue(ctx, msg); err != nil {
+			// Messages which have not been enqueued won't be delivered
+			for i := idx; i < len(msgs); i++ {
+				results[i] = err
+			}
+			wg.Add(idx - len(msgs))
+			break
+		}
+	}
+	var err = runWithContext(ctx, func() error {
+		wg.Wait()
 		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RunInTransaction runs fn in a transaction of the producer: messages sent by the producer while fn runs are committed if fn succeeds
+// and aborted otherwise. Transactions of a producer are serialized
+func (p *producer) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return p.runInTransaction(func() error { return fn(ctx) }, nil, "")
+}
+
+// runInTransaction runs fn in a transaction of the producer. When a consumed message is provided, its offset is committed for the given
+// consumer group in the same transaction
+func (p *producer) runInTransaction(fn func() error, consumed *sarama.ConsumerMessage, groupID string) error {
+	if p.transactionalID == nil {
+		return fmt.Errorf("producer %s is not transactional", p.id)
+	}
+	if !p.enabled {
+		return fn()
+	}
+	if !p.initialized {
+		return fmt.Errorf("producer %s is not initialized", p.id)
+	}
+	p.txnMutex.Lock()
+	defer p.txnMutex.Unlock()
+
+	if err := p.producer.BeginTxn(); err != nil {
+		p.logger.Error(context.Background(), "msg", "Failed to begin transaction", "producer", p.id, "err", err)
+		return err
+	}
+	var err = fn()
+	if err == nil && consumed != nil {
+		err = p.producer.AddMessageToTxn(consumed, groupID, nil)
+	}
+	if err == nil {
+		err = p.producer.CommitTxn()
+	}
+	if err != nil {
+		if abortErr := p.producer.AbortTxn(); abortErr != nil {
+			p.logger.Error(context.Background(), "msg", "Failed to abort transaction", "producer", p.id, "err", abortErr)
+		}
 	}
-	msg := &sarama.ProducerMessage{Topic: *p.topic, Key: sarama.StringEncoder(partitionKey), Value: sarama.StringEncoder(content)}
-	var _, _, err = p.producer.SendMessage(msg)
 	return err
 }
+
+// runWithContext runs a blocking function and waits for its result until the context is done. When the context is done first, the
+// function keeps running in the background
+func runWithContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		// Context can't be canceled: no need to wait in another goroutine
+		return fn()
+	}
+	var result = make(chan error, 1)
+	go func() {
+		result <- fn()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-result:
+		return err
+	}
+}
+
+// sendAndWait sends a message through the asynchronous producer and waits for its result until the context is done
+func (p *producer) sendAndWait(ctx context.Context, msg *sarama.ProducerMessage) (RecordMetadata, error) {
+	type result struct {
+		metadata RecordMetadata
+		err      error
+	}
+	var results = make(chan result, 1)
+	var metadata, ok = msg.Metadata.(*producerMetadata)
+	if !ok {
+		metadata = &producerMetadata{}
+		msg.Metadata = metadata
+	}
+	metadata.delivered = func(metadata RecordMetadata, err error) {
+		results <- result{metadata: metadata, err: err}
+	}
+	if err := p.enqueue(ctx, msg); err != nil {
+		return RecordMetadata{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return RecordMetadata{}, ctx.Err()
+	case res := <-results:
+		return res.metadata, res.err
+	}
+}
+
+// enqueue hands a message over to the asynchronous producer once an in-flight slot is available
+func (p *producer) enqueue(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if msg.Topic == "" {
+		msg.Topic = *p.topic
+	}
+	p.closeMutex.RLock()
+	defer p.closeMutex.RUnlock()
+	if p.closed {
+		return fmt.Errorf("producer %s is closed", p.id)
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case p.asyncProducer.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		<-p.inFlight
+		return ctx.Err()
+	}
+}
@@ -1,8 +1,12 @@
 package kafkauniverse
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/cloudtrust/kafka-client/mock"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -64,3 +68,377 @@ func TestInitialize(t *testing.T) {
 		assert.NotNil(t, producer.initialize())
 	})
 }
+
+func TestSendMessage(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+	var syncProducer = &producer{initialized: true, enabled: true, topic: new("topic"), producer: mockProducer}
+	var ctx = context.TODO()
+	var now = time.Now()
+	var anError = errors.New("an error")
+
+	t.Run("Disabled", func(t *testing.T) {
+		var disabled = &producer{initialized: true, enabled: false}
+		var metadata, err = disabled.SendMessage(ctx, Message{Value: []byte("value")})
+		assert.Nil(t, err)
+		assert.Equal(t, RecordMetadata{}, metadata)
+	})
+	t.Run("Not initialized", func(t *testing.T) {
+		var uninitialized = &producer{enabled: true, id: "producer1", topic: new("topic")}
+		var _, err = uninitialized.SendMessage(ctx, Message{Value: []byte("value")})
+		assert.Contains(t, err.Error(), "uninitialized producer")
+		_, err = uninitialized.SendMessages(ctx, []Message{{Value: []byte("value")}})
+		assert.Contains(t, err.Error(), "uninitialized producer")
+
+		var uninitializedAsync = &producer{enabled: true, async: true, id: "producer1", topic: new("topic")}
+		err = uninitializedAsync.SendMessageAsync(context.Background(), Message{Value: []byte("value")}, nil)
+		assert.Contains(t, err.Error(), "uninitialized producer")
+	})
+	t.Run("Success", func(t *testing.T) {
+		mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			assert.Equal(t, "topic", msg.Topic)
+			assert.Equal(t, sarama.ByteEncoder("key"), msg.Key)
+			assert.Equal(t, sarama.ByteEncoder("value"), msg.Value)
+			assert.Equal(t, now, msg.Timestamp)
+			assert.Equal(t, int32(3), *msg.Metadata.(*producerMetadata).partition)
+			assert.Equal(t, []sarama.RecordHeader{
+				{Key: []byte("a"), Value: []byte("1")},
+				{Key: []byte("b"), Value: []byte("2")},
+			}, msg.Headers)
+			return 3, 42, nil
+		})
+		var metadata, err = syncProducer.SendMessage(ctx, Message{
+			Key:       []byte("key"),
+			Value:     []byte("value"),
+			Headers:   map[string]string{"b": "2", "a": "1"},
+			Partition: new(int32(3)),
+			Timestamp: now,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, RecordMetadata{Topic: "topic", Partition: 3, Offset: 42}, metadata)
+	})
+	t.Run("Failure with cancelable context", func(t *testing.T) {
+		var cancelableCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		mockProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), anError)
+		var _, err = syncProducer.SendMessage(cancelableCtx, Message{Value: []byte("value")})
+		assert.Equal(t, anError, err)
+	})
+	t.Run("Context already done", func(t *testing.T) {
+		var canceledCtx, cancel = context.WithCancel(ctx)
+		cancel()
+		var _, err = syncProducer.SendMessage(canceledCtx, Message{Value: []byte("value")})
+		assert.Equal(t, context.Canceled, err)
+	})
+	t.Run("Deadline exceeded", func(t *testing.T) {
+		var timeoutCtx, cancel = context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		var release = make(chan struct{})
+		defer close(release)
+		mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+			<-release
+			return 0, 0, nil
+		})
+		var _, err = syncProducer.SendMessage(timeoutCtx, Message{Value: []byte("value")})
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestExplicitPartitioner(t *testing.T) {
+	var partitioner = newExplicitPartitioner(sarama.NewHashPartitioner)("topic")
+
+	t.Run("Explicit partition", func(t *testing.T) {
+		var partition, err = partitioner.Partition(&sarama.ProducerMessage{Metadata: &producerMetadata{partition: new(int32(2))}}, 4)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(2), partition)
+	})
+	t.Run("Invalid explicit partition", func(t *testing.T) {
+		var _, err = partitioner.Partition(&sarama.ProducerMessage{Metadata: &producerMetadata{partition: new(int32(4))}}, 4)
+		assert.Equal(t, sarama.ErrInvalidPartition, err)
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		var msg = &sarama.ProducerMessage{Key: sarama.StringEncoder("key"), Metadata: &producerMetadata{}}
+		var expected, _ = sarama.NewHashPartitioner("topic").Partition(msg, 4)
+		var partition, err = partitioner.Partition(msg, 4)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, partition)
+		assert.True(t, partitioner.RequiresConsistency())
+	})
+	t.Run("Consistency", func(t *testing.T) {
+		var randomPartitioner = newExplicitPartitioner(sarama.NewRandomPartitioner)("topic").(sarama.DynamicConsistencyPartitioner)
+		var explicit = &sarama.ProducerMessage{Metadata: &producerMetadata{partition: new(int32(2))}}
+		var withoutPartition = &sarama.ProducerMessage{Metadata: &producerMetadata{}}
+		assert.True(t, randomPartitioner.MessageRequiresConsistency(explicit))
+		assert.False(t, randomPartitioner.MessageRequiresConsistency(withoutPartition))
+
+		// The fallback decides for the messages without explicit partition
+		var hashPartitioner = partitioner.(sarama.DynamicConsistencyPartitioner)
+		assert.True(t, hashPartitioner.MessageRequiresConsistency(explicit))
+		assert.False(t, hashPartitioner.MessageRequiresConsistency(withoutPartition))
+		withoutPartition.Key = sarama.StringEncoder("key")
+		assert.True(t, hashPartitioner.MessageRequiresConsistency(withoutPartition))
+	})
+}
+
+func TestAsyncProducer(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockAsyncProducer = mock.NewAsyncProducer(mockCtrl)
+	var ctx = context.TODO()
+	var anError = errors.New("an error")
+
+	var input = make(chan *sarama.ProducerMessage)
+	var successes = make(chan *sarama.ProducerMessage)
+	var errs = make(chan *sarama.ProducerError)
+	mockAsyncProducer.EXPECT().Input().Return(input).AnyTimes()
+	mockAsyncProducer.EXPECT().Successes().Return(successes)
+	mockAsyncProducer.EXPECT().Errors().Return(errs)
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var asyncProducer = newProducer(&cluster{enabled: true}, KafkaProducerRepresentation{
+		ID:          new("producer1"),
+		Topic:       new("topic"),
+		Async:       new(true),
+		MaxInFlight: new(1),
+	}, logger)
+	asyncProducer.startAsync(mockAsyncProducer)
+	asyncProducer.initialized = true
+
+	// Simulates the broker: messages with a value "fail" are rejected
+	go func() {
+		var offset int64
+		for msg := range input {
+			offset++
+			msg.Offset = offset
+			if value, _ := msg.Value.Encode(); string(value) == "fail" {
+				errs <- &sarama.ProducerError{Msg: msg, Err: anError}
+			} else {
+				successes <- msg
+			}
+		}
+	}()
+
+	t.Run("Send and wait", func(t *testing.T) {
+		var metadata, err = asyncProducer.SendMessage(ctx, Message{Value: []byte("value")})
+		assert.Nil(t, err)
+		assert.Equal(t, "topic", metadata.Topic)
+		assert.Equal(t, int64(1), metadata.Offset)
+		assert.Equal(t, anError, asyncProducer.SendMessageBytes([]byte("fail")))
+	})
+	t.Run("Send asynchronously", func(t *testing.T) {
+		var results = make(chan error, 2)
+		var callback = func(metadata RecordMetadata, err error) { results <- err }
+		assert.Nil(t, asyncProducer.SendMessageAsync(ctx, Message{Value: []byte("value")}, callback))
+		assert.Nil(t, asyncProducer.SendMessageAsync(ctx, Message{Value: []byte("fail")}, callback))
+		assert.Nil(t, asyncProducer.SendMessageAsync(ctx, Message{Value: []byte("no callback")}, nil))
+		assert.Nil(t, asyncProducer.Flush(ctx))
+		assert.Len(t, results, 2)
+		assert.Nil(t, <-results)
+		assert.Equal(t, anError, <-results)
+	})
+	t.Run("Send batch", func(t *testing.T) {
+		var metadata, err = asyncProducer.SendMessages(ctx, []Message{{Value: []byte("value")}, {Value: []byte("fail")}})
+		var producerErrors ProducerErrors
+		assert.True(t, errors.As(err, &producerErrors))
+		assert.Len(t, producerErrors, 1)
+		assert.Equal(t, 1, producerErrors[0].Index)
+		assert.Equal(t, "topic", metadata[0].Topic)
+		assert.NotZero(t, metadata[0].Offset)
+	})
+	t.Run("No in-flight slot available", func(t *testing.T) {
+		asyncProducer.inFlight <- struct{}{}
+		defer func() { <-asyncProducer.inFlight }()
+
+		var timeoutCtx, cancel = context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, asyncProducer.SendMessageAsync(timeoutCtx, Message{Value: []byte("value")}, nil))
+		assert.Equal(t, context.DeadlineExceeded, asyncProducer.Flush(timeoutCtx))
+	})
+	t.Run("Close", func(t *testing.T) {
+		mockAsyncProducer.EXPECT().Close().DoAndReturn(func() error {
+			close(input)
+			close(successes)
+			close(errs)
+			return nil
+		})
+		assert.Nil(t, asyncProducer.Close())
+		assert.NotNil(t, asyncProducer.SendMessageAsync(ctx, Message{Value: []byte("value")}, nil))
+	})
+}
+
+func TestSendMessageAsyncWithSyncProducer(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+	var syncProducer = &producer{initialized: true, enabled: true, topic: new("topic"), producer: mockProducer}
+	var delivered RecordMetadata
+
+	mockProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(1), int64(2), nil)
+	assert.Nil(t, syncProducer.SendMessageAsync(context.TODO(), Message{Value: []byte("value")}, func(metadata RecordMetadata, err error) {
+		delivered = metadata
+	}))
+	assert.Equal(t, RecordMetadata{Topic: "topic", Partition: 1, Offset: 2}, delivered)
+	assert.Nil(t, syncProducer.Flush(context.TODO()))
+}
+
+func TestSendMessages(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+	var syncProducer = &producer{initialized: true, enabled: true, topic: new("topic"), producer: mockProducer}
+	var ctx = context.TODO()
+	var anError = errors.New("an error")
+	var messages = []Message{{Key: []byte("key-1"), Value: []byte("value-1")}, {Key: []byte("key-2"), Value: []byte("value-2")}}
+
+	t.Run("Disabled", func(t *testing.T) {
+		var disabled = &producer{initialized: true, enabled: false}
+		var metadata, err = disabled.SendMessages(ctx, messages)
+		assert.Nil(t, err)
+		assert.Len(t, metadata, 2)
+	})
+	t.Run("Context already done", func(t *testing.T) {
+		var canceledCtx, cancel = context.WithCancel(ctx)
+		cancel()
+		var _, err = syncProducer.SendMessages(canceledCtx, messages)
+		assert.Equal(t, context.Canceled, err)
+	})
+	t.Run("Success", func(t *testing.T) {
+		mockProducer.EXPECT().SendMessages(gomock.Any()).DoAndReturn(func(msgs []*sarama.ProducerMessage) error {
+			assert.Len(t, msgs, 2)
+			for idx, msg := range msgs {
+				assert.Equal(t, "topic", msg.Topic)
+				msg.Partition, msg.Offset = int32(idx), int64(10+idx)
+			}
+			return nil
+		})
+		var metadata, err = syncProducer.SendMessages(ctx, messages)
+		assert.Nil(t, err)
+		assert.Equal(t, []RecordMetadata{{Topic: "topic", Partition: 0, Offset: 10}, {Topic: "topic", Partition: 1, Offset: 11}}, metadata)
+	})
+	t.Run("Partial failure", func(t *testing.T) {
+		mockProducer.EXPECT().SendMessages(gomock.Any()).DoAndReturn(func(msgs []*sarama.ProducerMessage) error {
+			msgs[0].Offset = 10
+			return sarama.ProducerErrors{{Msg: msgs[1], Err: anError}}
+		})
+		var metadata, err = syncProducer.SendMessages(ctx, messages)
+		var producerErrors ProducerErrors
+		assert.True(t, errors.As(err, &producerErrors))
+		assert.True(t, errors.Is(err, anError))
+		assert.Len(t, producerErrors, 1)
+		assert.Equal(t, 1, producerErrors[0].Index)
+		assert.Equal(t, messages[1], producerErrors[0].Message)
+		assert.Equal(t, int64(10), metadata[0].Offset)
+		assert.Equal(t, RecordMetadata{}, metadata[1])
+	})
+	t.Run("Failure", func(t *testing.T) {
+		mockProducer.EXPECT().SendMessages(gomock.Any()).Return(anError)
+		var _, err = syncProducer.SendMessages(ctx, messages)
+		assert.Equal(t, anError, err)
+	})
+}
+
+func TestRunInTransaction(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+	var txnProducer = &producer{initialized: true, enabled: true, id: "producer", topic: new("topic"), transactionalID: new("txn"),
+		producer: mockProducer, logger: logger}
+	var ctx = context.TODO()
+	var anError = errors.New("an error")
+	var consumed = &sarama.ConsumerMessage{Topic: "input", Offset: 12}
+
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	t.Run("Not transactional", func(t *testing.T) {
+		var syncProducer = &producer{initialized: true, enabled: true, id: "producer", producer: mockProducer}
+		assert.NotNil(t, syncProducer.RunInTransaction(ctx, func(ctx context.Context) error { return nil }))
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		var disabled = &producer{initialized: true, enabled: false, transactionalID: new("txn")}
+		var called = false
+		assert.Nil(t, disabled.RunInTransaction(ctx, func(ctx context.Context) error { called = true; return nil }))
+		assert.True(t, called)
+	})
+	t.Run("Not initialized", func(t *testing.T) {
+		var uninitialized = &producer{enabled: true, transactionalID: new("txn")}
+		assert.NotNil(t, uninitialized.RunInTransaction(ctx, func(ctx context.Context) error { return nil }))
+	})
+	t.Run("Begin fails", func(t *testing.T) {
+		mockProducer.EXPECT().BeginTxn().Return(anError)
+		assert.Equal(t, anError, txnProducer.RunInTransaction(ctx, func(ctx context.Context) error { return nil }))
+	})
+	t.Run("Commit", func(t *testing.T) {
+		gomock.InOrder(
+			mockProducer.EXPECT().BeginTxn().Return(nil),
+			mockProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(1), nil),
+			mockProducer.EXPECT().CommitTxn().Return(nil),
+		)
+		assert.Nil(t, txnProducer.RunInTransaction(ctx, func(ctx context.Context) error {
+			var _, err = txnProducer.SendMessage(ctx, Message{Value: []byte("value")})
+			return err
+		}))
+	})
+	t.Run("Commit with consumed offset", func(t *testing.T) {
+		gomock.InOrder(
+			mockProducer.EXPECT().BeginTxn().Return(nil),
+			mockProducer.EXPECT().AddMessageToTxn(consumed, "group", nil).Return(nil),
+			mockProducer.EXPECT().CommitTxn().Return(nil),
+		)
+		assert.Nil(t, txnProducer.runInTransaction(func() error { return nil }, consumed, "group"))
+	})
+	t.Run("Abort when function fails", func(t *testing.T) {
+		mockProducer.EXPECT().BeginTxn().Return(nil)
+		mockProducer.EXPECT().AbortTxn().Return(nil)
+		assert.Equal(t, anError, txnProducer.runInTransaction(func() error { return anError }, consumed, "group"))
+	})
+	t.Run("Abort when commit fails", func(t *testing.T) {
+		mockProducer.EXPECT().BeginTxn().Return(nil)
+		mockProducer.EXPECT().AddMessageToTxn(consumed, "group", nil).Return(nil)
+		mockProducer.EXPECT().CommitTxn().Return(anError)
+		mockProducer.EXPECT().AbortTxn().Return(errors.New("abort error"))
+		assert.Equal(t, anError, txnProducer.runInTransaction(func() error { return nil }, consumed, "group"))
+	})
+}
+
+func TestProducerSaramaConfig(t *testing.T) {
+	var config = sarama.NewConfig()
+	var syncProducer = &producer{cluster: &cluster{saramaConfig: config}}
+	assert.Equal(t, config, syncProducer.saramaConfig())
+
+	var txnProducer = &producer{cluster: &cluster{saramaConfig: config}, transactionalID: new("txn")}
+	var txnConfig = txnProducer.saramaConfig()
+	assert.Equal(t, "txn", txnConfig.Producer.Transaction.ID)
+	assert.True(t, txnConfig.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, txnConfig.Producer.RequiredAcks)
+	assert.Equal(t, 1, txnConfig.Net.MaxOpenRequests)
+	assert.Equal(t, "", config.Producer.Transaction.ID)
+
+	t.Run("Transactional id per instance", func(t *testing.T) {
+		t.Setenv("KAFKA_TEST_POD_NAME", "pod-1")
+		var newTxnProducer = func(template string) *producer {
+			return newProducer(&cluster{enabled: true, saramaConfig: config}, KafkaProducerRepresentation{ID: new("txn-producer"),
+				TransactionalID: new(template)}, nil)
+		}
+		assert.Equal(t, "my-app-pod-1", *newTxnProducer("my-app-${KAFKA_TEST_POD_NAME}").transactionalID)
+		var uuidID = *newTxnProducer("my-app-<UUID>").transactionalID
+		assert.Regexp(t, "^my-app-[0-9a-f-]{36}$", uuidID)
+		assert.NotEqual(t, uuidID, *newTxnProducer("my-app-<UUID>").transactionalID)
+		assert.Equal(t, "my-app-txn", *newTxnProducer("my-app-txn").transactionalID)
+	})
+	t.Run("Tuning", func(t *testing.T) {
+		var tunedProducer = &producer{cluster: &cluster{saramaConfig: config},
+			saramaTuning: &KafkaSaramaProducerRepresentation{Compression: new("zstd"), MaxMessageBytes: new(2000000)}}
+		var tunedConfig = tunedProducer.saramaConfig()
+		assert.Equal(t, sarama.CompressionZSTD, tunedConfig.Producer.Compression)
+		assert.Equal(t, 2000000, tunedConfig.Producer.MaxMessageBytes)
+		assert.Equal(t, sarama.CompressionNone, config.Producer.Compression)
+	})
+}
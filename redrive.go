@@ -0,0 +1,263 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// RedriveOptions describes which messages of a failure topic are republished and where
+type RedriveOptions struct {
+	// ConsumerID is the ID of the consumer configured to read the failure topic. Its consumer group is not used: redriving does not commit any offset
+	ConsumerID string
+	// ProducerID is the ID of the producer used to republish the messages
+	ProducerID string
+	// TargetTopic overrides the topic where messages are republished. By default, the x-original-topic header is used when present,
+	// the topic of the producer otherwise
+	TargetTopic string
+	// From and To restrict the redrive to the messages produced in this time range. Zero values mean no limit
+	From time.Time
+	To   time.Time
+	// Keys restricts the redrive to the messages with one of these keys
+	Keys []string
+	// Headers restricts the redrive to the messages having all these header values
+	Headers map[string]string
+	// DryRun only reports the messages which would be redriven
+	DryRun bool
+	// IdleTimeout is the time without message after which a partition is no longer read, although its newest offset is not reached.
+	// Default is 5s
+	IdleTimeout time.Duration
+}
+
+// RedriveReport describes what has been done by a redrive
+type RedriveReport struct {
+	Scanned  int
+	Matched  int
+	Redriven int
+	Failed   int
+	Entries  []RedriveEntry
+	// Incomplete lists the partitions which were not read up to their newest offset before the idle timeout
+	Incomplete []IncompletePartition
+}
+
+// IncompletePartition describes a partition which was not fully read. Its last offsets may be transaction markers or compacted
+// messages, or it may have been fetched too slowly: the redrive can be resumed from NextOffset with a longer idle timeout
+type IncompletePartition struct {
+	Partition  int32
+	NextOffset int64
+	EndOffset  int64
+}
+
+// RedriveEntry describes a redriven message
+type RedriveEntry struct {
+	Partition   int32
+	Offset      int64
+	Key         string
+	TargetTopic string
+	Err         error
+}
+
+type offsetProvider interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// defaultRedriveIdleTimeout is the time after which a partition is no longer read when no message is received. The newest offsets of
+// a partition may not be reached when its last messages are transaction markers or have been compacted
+const defaultRedriveIdleTimeout = 5 * time.Second
+
+type redriver struct {
+	consumer    sarama.Consumer
+	offsets     offsetProvider
+	producer    *producer
+	logger      Logger
+	idleTimeout time.Duration
+}
+
+// Redrive republishes the messages of a failure topic matching the given options. The failure topic is read from its oldest message
+// (or from options.From) up to its newest message at the time of the call
+func (ku *KafkaUniverse) Redrive(ctx context.Context, options RedriveOptions) (*RedriveReport, error) {
+	var failureConsumer = ku.GetConsumer(options.ConsumerID)
+	if failureConsumer == nil {
+		return nil, fmt.Errorf("unknown consumer %s", options.ConsumerID)
+	}
+	var targetProducer = ku.GetProducer(options.ProducerID)
+	if targetProducer == nil {
+		return nil, fmt.Errorf("unknown producer %s", options.ProducerID)
+	}
+	if !options.DryRun && !targetProducer.initialized {
+		return nil, fmt.Errorf("producer %s is not initialized", options.ProducerID)
+	}
+	if !failureConsumer.cluster.enabled {
+		return nil, fmt.Errorf("cluster %s is disabled", failureConsumer.cluster.id)
+	}
+
+	var client, err = failureConsumer.cluster.newClient(failureConsumer.cluster.brokers, failureConsumer.cluster.saramaConfig)
+	if err != nil {
+		failureConsumer.logger.Error(ctx, "msg", "Failed to create Kafka client", "err", err)
+		return nil, err
+	}
+	defer client.Close()
+	saramaConsumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		failureConsumer.logger.Error(ctx, "msg", "Failed to create Kafka consumer", "err", err)
+		return nil, err
+	}
+	defer saramaConsumer.Close()
+
+	var r = &redriver{
+		consumer:    saramaConsumer,
+		offsets:     client,
+		producer:    targetProducer,
+		logger:      failureConsumer.logger,
+		idleTimeout: defaultRedriveIdleTimeout,
+	}
+	if options.IdleTimeout > 0 {
+		r.idleTimeout = options.IdleTimeout
+	}
+	return r.redrive(ctx, failureConsumer.topic, options)
+}
+
+func (r *redriver) redrive(ctx context.Context, topic string, options RedriveOptions) (*RedriveReport, error) {
+	partitions, err := r.consumer.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	var report = &RedriveReport{}
+	for _, partition := range partitions {
+		if err = r.redrivePartition(ctx, topic, partition, options, report); err != nil {
+			return report, err
+		}
+	}
+	r.logger.Info(ctx, "msg", "Redrive done", "topic", topic, "scanned", report.Scanned, "matched", report.Matched,
+		"redriven", report.Redriven, "failed", report.Failed, "incomplete", len(report.Incomplete), "dryRun", options.DryRun)
+	return report, nil
+}
+
+func (r *redriver) redrivePartition(ctx context.Context, topic string, partition int32, options RedriveOptions, report *RedriveReport) error {
+	var start = sarama.OffsetOldest
+	if !options.From.IsZero() {
+		start = options.From.UnixMilli()
+	}
+	startOffset, err := r.offsets.GetOffset(topic, partition, start)
+	if err != nil {
+		return err
+	}
+	endOffset, err := r.offsets.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	if startOffset < 0 || startOffset >= endOffset {
+		// Nothing to redrive in this partition
+		return nil
+	}
+
+	partitionConsumer, err := r.consumer.ConsumePartition(topic, partition, startOffset)
+	if err != nil {
+		return err
+	}
+	defer partitionConsumer.AsyncClose()
+
+	var messages, errs = partitionConsumer.Messages(), partitionConsumer.Errors()
+	var nextOffset = startOffset
+	var idle = time.NewTimer(r.idleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle.C:
+			r.logger.Warn(ctx, "msg", "Partition not fully redriven: no message received before the idle timeout", "topic", topic,
+				"partition", partition, "nextOffset", nextOffset, "endOffset", endOffset, "idleTimeout", r.idleTimeout)
+			report.Incomplete = append(report.Incomplete, IncompletePartition{Partition: partition, NextOffset: nextOffset, EndOffset: endOffset})
+			return nil
+		case consumerErr, ok := <-errs:
+			if ok {
+				return consumerErr
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("partition consumer closed before the end of the redrive")
+			}
+			report.Scanned++
+			if matchesRedriveOptions(msg, options) {
+				report.Matched++
+				report.Entries = append(report.Entries, r.redriveMessage(ctx, msg, options, report))
+			}
+			nextOffset = msg.Offset + 1
+			if nextOffset >= endOffset {
+				return nil
+			}
+			idle.Reset(r.idleTimeout)
+		}
+	}
+}
+
+func (r *redriver) redriveMessage(ctx context.Context, msg *sarama.ConsumerMessage, options RedriveOptions, report *RedriveReport) RedriveEntry {
+	var entry = RedriveEntry{
+		Partition:   msg.Partition,
+		Offset:      msg.Offset,
+		Key:         string(msg.Key),
+		TargetTopic: options.TargetTopic,
+	}
+	if entry.TargetTopic == "" {
+		entry.TargetTopic = *r.producer.topic
+		if originalTopic, ok := findHeader(msg.Headers, HeaderOriginalTopic); ok {
+			entry.TargetTopic = originalTopic
+		}
+	}
+	if options.DryRun {
+		return entry
+	}
+
+	var redriven = &sarama.ProducerMessage{
+		Topic: entry.TargetTopic,
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	if msg.Key != nil {
+		redriven.Key = sarama.ByteEncoder(msg.Key)
+	}
+	for _, header := range msg.Headers {
+		// The redriven message starts a new processing: failure details are removed
+		if !slices.Contains([]string{HeaderRetryAttempt, HeaderExceptionMessage, HeaderExceptionType}, string(header.Key)) {
+			redriven.Headers = append(redriven.Headers, *header)
+		}
+	}
+	if entry.Err = r.producer.sendMessage(redriven); entry.Err != nil {
+		r.logger.Warn(ctx, "msg", "Failed to redrive message", "err", entry.Err, "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+		report.Failed++
+	} else {
+		report.Redriven++
+	}
+	return entry
+}
+
+func matchesRedriveOptions(msg *sarama.ConsumerMessage, options RedriveOptions) bool {
+	if !options.From.IsZero() && msg.Timestamp.Before(options.From) {
+		return false
+	}
+	if !options.To.IsZero() && msg.Timestamp.After(options.To) {
+		return false
+	}
+	if len(options.Keys) > 0 && !slices.Contains(options.Keys, string(msg.Key)) {
+		return false
+	}
+	for name, expected := range options.Headers {
+		if value, ok := findHeader(msg.Headers, name); !ok || value != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func findHeader(headers []*sarama.RecordHeader, name string) (string, bool) {
+	for _, header := range headers {
+		if string(header.Key) == name {
+			return string(header.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,201 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRedrive(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var ctx = context.TODO()
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var universe, _ = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+		var conf = target.(*[]KafkaClusterRepresentation)
+		*conf = append(*conf, createValidKafkaClusterRepresentation())
+		return nil
+	})
+
+	t.Run("Unknown consumer", func(t *testing.T) {
+		var _, err = universe.Redrive(ctx, RedriveOptions{ConsumerID: "unknown", ProducerID: "producer-1"})
+		assert.NotNil(t, err)
+	})
+	t.Run("Unknown producer", func(t *testing.T) {
+		var _, err = universe.Redrive(ctx, RedriveOptions{ConsumerID: "consumer-1", ProducerID: "unknown"})
+		assert.NotNil(t, err)
+	})
+	t.Run("Producer not initialized", func(t *testing.T) {
+		var _, err = universe.Redrive(ctx, RedriveOptions{ConsumerID: "consumer-1", ProducerID: "producer-1"})
+		assert.NotNil(t, err)
+	})
+	t.Run("Cluster disabled", func(t *testing.T) {
+		universe.GetConsumer("consumer-1").cluster.enabled = false
+		defer func() { universe.GetConsumer("consumer-1").cluster.enabled = true }()
+		var _, err = universe.Redrive(ctx, RedriveOptions{ConsumerID: "consumer-1", ProducerID: "producer-1", DryRun: true})
+		assert.NotNil(t, err)
+	})
+	t.Run("Brokers not available", func(t *testing.T) {
+		var anError = errors.New("brokers not available")
+		universe.GetConsumer("consumer-1").cluster.newClient = func([]string, *sarama.Config) (sarama.Client, error) {
+			return nil, anError
+		}
+		var _, err = universe.Redrive(ctx, RedriveOptions{ConsumerID: "consumer-1", ProducerID: "producer-1", DryRun: true})
+		assert.Equal(t, anError, err)
+	})
+}
+
+func TestRedriver(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var mockConsumer = mock.NewConsumer(mockCtrl)
+	var mockPartitionConsumer = mock.NewPartitionConsumer(mockCtrl)
+	var mockClient = mock.NewClient(mockCtrl)
+	var mockProducer = mock.NewSyncProducer(mockCtrl)
+	var ctx = context.TODO()
+	var anError = errors.New("an error")
+	var failureTopic = "failure-topic"
+	var now = time.Now()
+
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var r = &redriver{
+		consumer:    mockConsumer,
+		offsets:     mockClient,
+		producer:    &producer{initialized: true, enabled: true, topic: new("default-topic"), producer: mockProducer},
+		logger:      logger,
+		idleTimeout: time.Minute,
+	}
+	var newMessages = func() chan *sarama.ConsumerMessage {
+		var messages = make(chan *sarama.ConsumerMessage, 3)
+		messages <- &sarama.ConsumerMessage{Offset: 10, Key: []byte("key-1"), Value: []byte("value-1"), Timestamp: now,
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte(HeaderOriginalTopic), Value: []byte("original-topic")},
+				{Key: []byte(HeaderExceptionMessage), Value: []byte("an error")},
+			}}
+		messages <- &sarama.ConsumerMessage{Offset: 11, Key: []byte("key-2"), Value: []byte("value-2"), Timestamp: now}
+		messages <- &sarama.ConsumerMessage{Offset: 12, Key: []byte("key-3"), Value: []byte("value-3"), Timestamp: now.Add(-time.Hour)}
+		return messages
+	}
+
+	t.Run("Partitions failure", func(t *testing.T) {
+		mockConsumer.EXPECT().Partitions(failureTopic).Return(nil, anError)
+		var _, err = r.redrive(ctx, failureTopic, RedriveOptions{})
+		assert.Equal(t, anError, err)
+	})
+	t.Run("Empty partition", func(t *testing.T) {
+		mockConsumer.EXPECT().Partitions(failureTopic).Return([]int32{0}, nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetOldest).Return(int64(10), nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetNewest).Return(int64(10), nil)
+		var report, err = r.redrive(ctx, failureTopic, RedriveOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, report.Scanned)
+	})
+	t.Run("Dry run with filters", func(t *testing.T) {
+		var from = now.Add(-time.Minute)
+		mockConsumer.EXPECT().Partitions(failureTopic).Return([]int32{0}, nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), from.UnixMilli()).Return(int64(10), nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetNewest).Return(int64(13), nil)
+		mockConsumer.EXPECT().ConsumePartition(failureTopic, int32(0), int64(10)).Return(mockPartitionConsumer, nil)
+		mockPartitionConsumer.EXPECT().Messages().Return(newMessages())
+		mockPartitionConsumer.EXPECT().Errors().Return(nil)
+		mockPartitionConsumer.EXPECT().AsyncClose()
+
+		var report, err = r.redrive(ctx, failureTopic, RedriveOptions{From: from, Keys: []string{"key-1", "key-3"}, DryRun: true})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, report.Scanned)
+		assert.Equal(t, 1, report.Matched)
+		assert.Equal(t, 0, report.Redriven)
+		assert.Equal(t, "original-topic", report.Entries[0].TargetTopic)
+		assert.Empty(t, report.Incomplete)
+	})
+	t.Run("Redrive", func(t *testing.T) {
+		mockConsumer.EXPECT().Partitions(failureTopic).Return([]int32{0}, nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetOldest).Return(int64(10), nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetNewest).Return(int64(13), nil)
+		mockConsumer.EXPECT().ConsumePartition(failureTopic, int32(0), int64(10)).Return(mockPartitionConsumer, nil)
+		mockPartitionConsumer.EXPECT().Messages().Return(newMessages())
+		mockPartitionConsumer.EXPECT().Errors().Return(nil)
+		mockPartitionConsumer.EXPECT().AsyncClose()
+		gomock.InOrder(
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				assert.Equal(t, "original-topic", msg.Topic)
+				assert.Equal(t, map[string]string{HeaderOriginalTopic: "original-topic"}, headersAsMap(msg.Headers))
+				return 0, 0, nil
+			}),
+			mockProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+				assert.Equal(t, "default-topic", msg.Topic)
+				return 0, 0, anError
+			}),
+		)
+
+		var report, err = r.redrive(ctx, failureTopic, RedriveOptions{To: now, Keys: []string{"key-1", "key-2"}})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, report.Scanned)
+		assert.Equal(t, 2, report.Matched)
+		assert.Equal(t, 1, report.Redriven)
+		assert.Equal(t, 1, report.Failed)
+		assert.Equal(t, anError, report.Entries[1].Err)
+	})
+	t.Run("Newest offset not reached", func(t *testing.T) {
+		// The last offsets are transaction markers or have been compacted
+		var idleRedriver = *r
+		idleRedriver.idleTimeout = 50 * time.Millisecond
+		mockConsumer.EXPECT().Partitions(failureTopic).Return([]int32{0}, nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetOldest).Return(int64(10), nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetNewest).Return(int64(20), nil)
+		mockConsumer.EXPECT().ConsumePartition(failureTopic, int32(0), int64(10)).Return(mockPartitionConsumer, nil)
+		mockPartitionConsumer.EXPECT().Messages().Return(newMessages())
+		mockPartitionConsumer.EXPECT().Errors().Return(nil)
+		mockPartitionConsumer.EXPECT().AsyncClose()
+
+		var report, err = idleRedriver.redrive(ctx, failureTopic, RedriveOptions{DryRun: true})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, report.Scanned)
+		assert.Equal(t, 3, report.Matched)
+		assert.Equal(t, []IncompletePartition{{Partition: 0, NextOffset: 13, EndOffset: 20}}, report.Incomplete)
+	})
+	t.Run("Context canceled", func(t *testing.T) {
+		var canceledCtx, cancel = context.WithCancel(ctx)
+		cancel()
+		mockConsumer.EXPECT().Partitions(failureTopic).Return([]int32{0}, nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetOldest).Return(int64(10), nil)
+		mockClient.EXPECT().GetOffset(failureTopic, int32(0), sarama.OffsetNewest).Return(int64(13), nil)
+		mockConsumer.EXPECT().ConsumePartition(failureTopic, int32(0), int64(10)).Return(mockPartitionConsumer, nil)
+		mockPartitionConsumer.EXPECT().Messages().Return(make(chan *sarama.ConsumerMessage))
+		mockPartitionConsumer.EXPECT().Errors().Return(nil)
+		mockPartitionConsumer.EXPECT().AsyncClose()
+
+		var _, err = r.redrive(canceledCtx, failureTopic, RedriveOptions{})
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestMatchesRedriveOptions(t *testing.T) {
+	var now = time.Now()
+	var msg = &sarama.ConsumerMessage{
+		Key:       []byte("key"),
+		Timestamp: now,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("name"), Value: []byte("value")}},
+	}
+	assert.True(t, matchesRedriveOptions(msg, RedriveOptions{}))
+	assert.True(t, matchesRedriveOptions(msg, RedriveOptions{From: now.Add(-time.Second), To: now, Keys: []string{"key"},
+		Headers: map[string]string{"name": "value"}}))
+	assert.False(t, matchesRedriveOptions(msg, RedriveOptions{From: now.Add(time.Second)}))
+	assert.False(t, matchesRedriveOptions(msg, RedriveOptions{To: now.Add(-time.Second)}))
+	assert.False(t, matchesRedriveOptions(msg, RedriveOptions{Keys: []string{"other"}}))
+	assert.False(t, matchesRedriveOptions(msg, RedriveOptions{Headers: map[string]string{"name": "other"}}))
+	assert.False(t, matchesRedriveOptions(msg, RedriveOptions{Headers: map[string]string{"missing": "value"}}))
+}
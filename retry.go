@@ -0,0 +1,57 @@
+package kafkauniverse
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+)
+
+// KafkaRetryableErrorPredicate tells if a handler error is worth retrying
+type KafkaRetryableErrorPredicate func(err error) bool
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+}
+
+func newRetryPolicy(retryRep *KafkaRetryRepresentation) *retryPolicy {
+	if retryRep == nil {
+		return nil
+	}
+	var policy = &retryPolicy{
+		maxAttempts:    *retryRep.MaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+	}
+	if retryRep.InitialBackoff != nil {
+		policy.initialBackoff = *retryRep.InitialBackoff
+	}
+	policy.maxBackoff = max(policy.maxBackoff, policy.initialBackoff)
+	if retryRep.MaxBackoff != nil {
+		policy.maxBackoff = *retryRep.MaxBackoff
+	}
+	if retryRep.Jitter != nil {
+		policy.jitter = *retryRep.Jitter
+	}
+	return policy
+}
+
+// backoff computes the delay to wait before the next attempt. The delay is doubled after each attempt and randomized
+// by +/- jitter percent
+func (rp *retryPolicy) backoff(attempt int) time.Duration {
+	var delay = rp.initialBackoff
+	for i := 1; i < attempt && delay < rp.maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, rp.maxBackoff)
+	if rp.jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + rp.jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
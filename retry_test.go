@@ -0,0 +1,45 @@
+package kafkauniverse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("Not configured", func(t *testing.T) {
+		assert.Nil(t, newRetryPolicy(nil))
+	})
+	t.Run("Default values", func(t *testing.T) {
+		var policy = newRetryPolicy(&KafkaRetryRepresentation{MaxAttempts: new(3)})
+		assert.Equal(t, 3, policy.maxAttempts)
+		assert.Equal(t, defaultRetryInitialBackoff, policy.initialBackoff)
+		assert.Equal(t, defaultRetryMaxBackoff, policy.maxBackoff)
+		assert.Equal(t, 0.0, policy.jitter)
+	})
+	t.Run("Exponential backoff", func(t *testing.T) {
+		var policy = newRetryPolicy(&KafkaRetryRepresentation{
+			MaxAttempts:    new(10),
+			InitialBackoff: new(time.Second),
+			MaxBackoff:     new(5 * time.Second),
+		})
+		assert.Equal(t, time.Second, policy.backoff(1))
+		assert.Equal(t, 2*time.Second, policy.backoff(2))
+		assert.Equal(t, 4*time.Second, policy.backoff(3))
+		assert.Equal(t, 5*time.Second, policy.backoff(4))
+		assert.Equal(t, 5*time.Second, policy.backoff(100))
+	})
+	t.Run("Jitter", func(t *testing.T) {
+		var policy = newRetryPolicy(&KafkaRetryRepresentation{
+			MaxAttempts:    new(10),
+			InitialBackoff: new(time.Second),
+			Jitter:         new(0.5),
+		})
+		for range 20 {
+			var backoff = policy.backoff(1)
+			assert.GreaterOrEqual(t, backoff, 500*time.Millisecond)
+			assert.LessOrEqual(t, backoff, 1500*time.Millisecond)
+		}
+	})
+}
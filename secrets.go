@@ -0,0 +1,85 @@
+package kafkauniverse
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudtrust/kafka-client/misc"
+)
+
+// Schemes of the built-in secret resolvers
+const (
+	SecretSchemeFile = "file"
+	SecretSchemeEnv  = "env"
+)
+
+// SecretResolver resolves the secrets referenced in the configuration as <scheme>:<name>. Secrets are resolved again each time they are
+// used, so a resolver should return the current value of the secret to support rotation
+type SecretResolver interface {
+	ResolveSecret(name string) (string, error)
+}
+
+// FileSecretResolver reads secrets from files. Trailing new lines are removed
+type FileSecretResolver struct{}
+
+// ResolveSecret reads the secret stored in the given file
+func (FileSecretResolver) ResolveSecret(name string) (string, error) {
+	var content, err = os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// EnvSecretResolver reads secrets from environment variables
+type EnvSecretResolver struct{}
+
+// ResolveSecret reads the secret stored in the given environment variable
+func (EnvSecretResolver) ResolveSecret(name string) (string, error) {
+	var value, ok = os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// WithSecretResolver registers a resolver for the secret references using the given scheme. It replaces the built-in resolver of the
+// scheme, if any
+func WithSecretResolver(scheme string, resolver SecretResolver) UniverseOption {
+	return func(options *universeOptions) {
+		options.secretResolvers[scheme] = resolver
+	}
+}
+
+type secretResolvers map[string]SecretResolver
+
+func newSecretResolvers() secretResolvers {
+	return secretResolvers{
+		SecretSchemeFile: FileSecretResolver{},
+		SecretSchemeEnv:  EnvSecretResolver{},
+	}
+}
+
+// provider creates a provider of the given configured secret. The secret is resolved once to detect invalid references at startup
+func (sr secretResolvers) provider(value string) (misc.SecretProvider, error) {
+	if _, err := sr.resolve(value); err != nil {
+		return nil, err
+	}
+	return func() (string, error) {
+		return sr.resolve(value)
+	}, nil
+}
+
+// resolve gets the current value of the given configured secret. Values which are not references to a registered scheme are used as is
+func (sr secretResolvers) resolve(value string) (string, error) {
+	var scheme, name, found = strings.Cut(value, ":")
+	if resolver, ok := sr[scheme]; found && ok {
+		var secret, err = resolver.ResolveSecret(name)
+		if err != nil {
+			return "", fmt.Errorf("can't resolve secret %s: %w", value, err)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
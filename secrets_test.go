@@ -0,0 +1,60 @@
+package kafkauniverse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticSecretResolver map[string]string
+
+func (r staticSecretResolver) ResolveSecret(name string) (string, error) {
+	if value, ok := r[name]; ok {
+		return value, nil
+	}
+	return "", errors.New("unknown secret")
+}
+
+func TestSecretResolvers(t *testing.T) {
+	var secretFile = filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+	t.Setenv("KAFKA_TEST_SECRET", "env-secret")
+
+	var options = universeOptions{secretResolvers: newSecretResolvers()}
+	WithSecretResolver("vault", staticSecretResolver{"kafka/password": "vault-secret"})(&options)
+	var secrets = options.secretResolvers
+
+	t.Run("Resolve", func(t *testing.T) {
+		for value, expected := range map[string]string{
+			"literal":                   "literal",
+			"unknown:scheme":            "unknown:scheme",
+			"file:" + secretFile:        "file-secret",
+			"env:KAFKA_TEST_SECRET":     "env-secret",
+			"vault:kafka/password":      "vault-secret",
+			"literal-with-no-separator": "literal-with-no-separator",
+		} {
+			var secret, err = secrets.resolve(value)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, secret)
+		}
+	})
+	t.Run("Invalid references", func(t *testing.T) {
+		for _, value := range []string{"file:" + filepath.Join(t.TempDir(), "missing"), "env:KAFKA_TEST_MISSING_SECRET", "vault:unknown"} {
+			var _, err = secrets.resolve(value)
+			assert.NotNil(t, err)
+			_, err = secrets.provider(value)
+			assert.NotNil(t, err)
+		}
+	})
+	t.Run("Rotation", func(t *testing.T) {
+		var provider, err = secrets.provider("file:" + secretFile)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(secretFile, []byte("rotated-secret"), 0600))
+		secret, err := provider()
+		assert.Nil(t, err)
+		assert.Equal(t, "rotated-secret", secret)
+	})
+}
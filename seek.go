@@ -0,0 +1,361 @@
+package kafkauniverse
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetStore gives the offsets needed to reset the position of a consumer group
+type offsetStore interface {
+	offsetProvider
+	committedOffsets(group string, topic string, partitions []int32) (map[int32]committedOffset, error)
+	Close() error
+}
+
+// committedOffset is the position committed by a consumer group for a partition. The offset is -1 when nothing has been committed
+type committedOffset struct {
+	offset   int64
+	metadata string
+}
+
+type clusterOffsetStore struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+func (c *cluster) newOffsetStore() (offsetStore, error) {
+	var client, err = c.newClient(c.brokers, c.saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &clusterOffsetStore{client: client, admin: admin}, nil
+}
+
+func (s *clusterOffsetStore) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	return s.client.GetOffset(topic, partitionID, time)
+}
+
+// committedOffsets gets the offsets committed by a consumer group, with their metadata
+func (s *clusterOffsetStore) committedOffsets(group string, topic string, partitions []int32) (map[int32]committedOffset, error) {
+	var response, err = s.admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, err
+	}
+	var offsets = map[int32]committedOffset{}
+	for _, partition := range partitions {
+		offsets[partition] = committedOffset{offset: -1}
+		if block := response.GetBlock(topic, partition); block != nil {
+			if block.Err != sarama.ErrNoError {
+				return nil, block.Err
+			}
+			offsets[partition] = committedOffset{offset: block.Offset, metadata: block.Metadata}
+		}
+	}
+	return offsets, nil
+}
+
+// Close closes the admin client, which also closes the client
+func (s *clusterOffsetStore) Close() error {
+	return s.admin.Close()
+}
+
+// parseOffsetTime parses an RFC3339 timestamp or a negative duration, relative to the time when the offsets are reset
+func parseOffsetTime(value string) (func() time.Time, bool) {
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return func() time.Time { return timestamp }, true
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration < 0 {
+		return func() time.Time { return time.Now().Add(duration) }, true
+	}
+	return nil, false
+}
+
+// offsetReset describes how the offsets of the claimed partitions are reset: to a time, to absolute offsets or by a relative amount
+type offsetReset struct {
+	time    func() time.Time
+	offsets map[int32]int64
+	shift   int64
+	// marker identifies a configured reset in the metadata of the committed offsets, so that it is applied once to each partition
+	marker string
+}
+
+func newOffsetReset(resetRep *KafkaOffsetResetRepresentation) *offsetReset {
+	if resetRep == nil {
+		return nil
+	}
+	var reset = &offsetReset{offsets: resetRep.Offsets}
+	var toTime string
+	if resetRep.ToTime != nil {
+		toTime = *resetRep.ToTime
+		reset.time, _ = parseOffsetTime(toTime)
+	}
+	if resetRep.Shift != nil {
+		reset.shift = *resetRep.Shift
+	}
+	// Maps are printed sorted by key: the marker only changes with the configuration
+	var hash = fnv.New32a()
+	_, _ = fmt.Fprintf(hash, "%s|%v|%d", toTime, resetRep.Offsets, reset.shift)
+	reset.marker = fmt.Sprintf("reset-offsets:%08x", hash.Sum32())
+	return reset
+}
+
+// tagMetadata adds the marker of the configured reset, if any, to the metadata of a committed offset
+func tagMetadata(marker string, metadata string) string {
+	switch {
+	case marker == "" || strings.HasPrefix(metadata, marker):
+		return metadata
+	case metadata == "":
+		return marker
+	}
+	return marker + " " + metadata
+}
+
+// target computes the new offset of a partition. It returns false if the partition is not concerned by the reset
+func (r *offsetReset) target(store offsetStore, topic string, partition int32, committed int64) (int64, bool, error) {
+	var offset int64
+	switch {
+	case r.time != nil:
+		return offsetForTime(store, topic, partition, r.time())
+	case r.offsets != nil:
+		var ok bool
+		if offset, ok = r.offsets[partition]; !ok {
+			return 0, false, nil
+		}
+	default:
+		if committed < 0 {
+			// Nothing to shift
+			return 0, false, nil
+		}
+		offset = committed + r.shift
+	}
+
+	// Keep the offset in the range of the partition
+	var oldest, err = store.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, false, err
+	}
+	newest, err := store.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, false, err
+	}
+	return min(max(offset, oldest), newest), true, nil
+}
+
+// offsetForTime finds the offset of the first message of a partition produced at or after the given time
+func offsetForTime(store offsetStore, topic string, partition int32, t time.Time) (int64, bool, error) {
+	var offset, err = store.GetOffset(topic, partition, t.UnixMilli())
+	if err != nil {
+		return 0, false, err
+	}
+	if offset < 0 {
+		// No message produced after this time
+		if offset, err = store.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+			return 0, false, err
+		}
+	}
+	return offset, true, nil
+}
+
+// ResetOffsetsToTime resets the offsets of the partitions claimed by the consumer to the first messages produced at or after the given
+// time. The current session ends and the reset is applied when the next one starts
+func (c *consumer) ResetOffsetsToTime(t time.Time) {
+	c.requestOffsetReset(&offsetReset{time: func() time.Time { return t }})
+}
+
+// ResetOffsets resets the offsets of the given partitions, if they are claimed by the consumer. Offsets are kept in the range of each
+// partition. The current session ends and the reset is applied when the next one starts
+func (c *consumer) ResetOffsets(offsets map[int32]int64) {
+	c.requestOffsetReset(&offsetReset{offsets: offsets})
+}
+
+// ShiftOffsets moves the committed offsets of the partitions claimed by the consumer: a negative shift replays messages, a positive one
+// skips messages. The current session ends and the reset is applied when the next one starts
+func (c *consumer) ShiftOffsets(shift int64) {
+	c.requestOffsetReset(&offsetReset{shift: shift})
+}
+
+func (c *consumer) requestOffsetReset(reset *offsetReset) {
+	c.resetMutex.Lock()
+	defer c.resetMutex.Unlock()
+	c.offsetReset = reset
+	c.resetCancel()
+}
+
+// takeOffsetReset returns the pending offset reset, if any, and the context ending the sessions when a new reset is requested
+func (c *consumer) takeOffsetReset() (*offsetReset, context.Context) {
+	c.resetMutex.Lock()
+	defer c.resetMutex.Unlock()
+	var reset = c.offsetReset
+	c.offsetReset = nil
+	if c.resetCtx.Err() != nil {
+		c.resetCtx, c.resetCancel = context.WithCancel(context.Background())
+	}
+	return reset, c.resetCtx
+}
+
+// restoreOffsetReset keeps a reset which could not be applied for the next session, unless another one has been requested
+func (c *consumer) restoreOffsetReset(reset *offsetReset) {
+	c.resetMutex.Lock()
+	defer c.resetMutex.Unlock()
+	if c.offsetReset == nil {
+		c.offsetReset = reset
+	}
+}
+
+// resetMarker returns the marker of the configured reset, or an empty string if no reset is configured
+func (c *consumer) resetMarker() string {
+	if c.configuredReset == nil {
+		return ""
+	}
+	return c.configuredReset.marker
+}
+
+// getOffsetStore returns the offset store of the consumer. It is created on first use and kept until the consumer is closed
+func (c *consumer) getOffsetStore() (offsetStore, error) {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	if c.store == nil {
+		var store, err = c.newOffsetStore()
+		if err != nil {
+			return nil, err
+		}
+		c.store = store
+	}
+	return c.store, nil
+}
+
+// closeOffsetStore closes the offset store of the consumer, if it has been created
+func (c *consumer) closeOffsetStore() error {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	if c.store == nil {
+		return nil
+	}
+	var err = c.store.Close()
+	c.store = nil
+	return err
+}
+
+// seekClaims positions the claimed partitions of a new session: a requested offset reset is applied to all of them. Otherwise, the
+// configured reset is applied to the partitions whose committed offset doesn't carry its marker yet and the partitions without
+// committed offset are positioned at the initial time of the consumer, if any. Offsets are only looked up for the partitions which
+// are not known to be positioned. Failures are retried until the session ends
+func (c *consumer) seekClaims(session sarama.ConsumerGroupSession, partitions []int32) error {
+	var reset, _ = c.takeOffsetReset()
+	if reset == nil {
+		if c.configuredReset == nil && c.initialTime == nil {
+			return nil
+		}
+		partitions = slices.DeleteFunc(slices.Clone(partitions), func(partition int32) bool { return c.positioned[partition] })
+	}
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	var backoff = c.restartBackoff
+	for {
+		var err = c.seekPartitions(session, partitions, reset)
+		if err == nil {
+			return nil
+		}
+		c.logger.Warn(context.Background(), "msg", "Failed to position the claimed partitions. Retry", "err", err, "topic", c.topic,
+			"group", c.consumerGroupName, "backoff", backoff)
+		select {
+		case <-session.Context().Done():
+			if reset != nil {
+				c.restoreOffsetReset(reset)
+			}
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.restartMaxBackoff)
+	}
+}
+
+func (c *consumer) seekPartitions(session sarama.ConsumerGroupSession, partitions []int32, reset *offsetReset) error {
+	var ctx = context.Background()
+	var store, err = c.getOffsetStore()
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	committed, err := store.committedOffsets(c.consumerGroupName, c.topic, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to get committed offsets: %w", err)
+	}
+
+	var marker = c.resetMarker()
+	for _, partition := range partitions {
+		var current = committed[partition]
+		var offset int64
+		var ok bool
+		if reset != nil {
+			offset, ok, err = reset.target(store, c.topic, partition, current.offset)
+		} else if marker != "" && !strings.HasPrefix(current.metadata, marker) {
+			offset, ok, err = c.configuredReset.target(store, c.topic, partition, current.offset)
+		}
+		if err == nil && !ok && current.offset < 0 && c.initialTime != nil {
+			offset, ok, err = offsetForTime(store, c.topic, partition, c.initialTime())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to compute offset of partition %d: %w", partition, err)
+		}
+		if ok {
+			// Sarama only moves offsets backward with ResetOffset and forward with MarkOffset. The marker of the configured reset
+			// is kept in the metadata, so that it is not applied again
+			session.ResetOffset(c.topic, partition, offset, marker)
+			session.MarkOffset(c.topic, partition, offset, marker)
+			c.logger.Info(ctx, "msg", "Offset reset", "topic", c.topic, "partition", partition, "offset", offset,
+				"committed", current.offset, "group", c.consumerGroupName)
+		}
+		if ok || current.offset >= 0 {
+			c.positioned[partition] = true
+		}
+	}
+	return nil
+}
+
+// resettableSession ends the context of a session when an offset reset is requested, so that the reset is applied by a new session.
+// The marker of the configured reset, if any, is added to the metadata of the marked offsets
+type resettableSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marker string
+}
+
+func (s *resettableSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *resettableSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, tagMetadata(s.marker, metadata))
+}
+
+func (s *resettableSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.ConsumerGroupSession.MarkMessage(msg, tagMetadata(s.marker, metadata))
+}
+
+// withResetContext returns a session whose context also ends when an offset reset is requested. The returned function releases the
+// resources of this context
+func (c *consumer) withResetContext(session sarama.ConsumerGroupSession) (sarama.ConsumerGroupSession, context.CancelFunc) {
+	c.resetMutex.Lock()
+	var resetCtx = c.resetCtx
+	c.resetMutex.Unlock()
+
+	var ctx, cancel = context.WithCancel(session.Context())
+	var stop = context.AfterFunc(resetCtx, cancel)
+	return &resettableSession{ConsumerGroupSession: session, ctx: ctx, marker: c.resetMarker()}, func() {
+		stop()
+		cancel()
+	}
+}
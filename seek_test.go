@@ -0,0 +1,329 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type fakeOffsetStore struct {
+	committed map[int32]int64
+	metadata  map[int32]string
+	byTime    map[int64]int64
+	oldest    int64
+	newest    int64
+	err       error
+	// failures is the number of next calls to committedOffsets which fail
+	failures int
+	closed   bool
+}
+
+func (s *fakeOffsetStore) GetOffset(_ string, _ int32, t int64) (int64, error) {
+	switch t {
+	case sarama.OffsetOldest:
+		return s.oldest, s.err
+	case sarama.OffsetNewest:
+		return s.newest, s.err
+	}
+	if offset, ok := s.byTime[t]; ok {
+		return offset, s.err
+	}
+	return -1, s.err
+}
+
+func (s *fakeOffsetStore) committedOffsets(_ string, _ string, partitions []int32) (map[int32]committedOffset, error) {
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("coordinator not available")
+	}
+	var offsets = map[int32]committedOffset{}
+	for _, partition := range partitions {
+		offsets[partition] = committedOffset{offset: s.committed[partition], metadata: s.metadata[partition]}
+	}
+	return offsets, nil
+}
+
+func (s *fakeOffsetStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestNewOffsetStore(t *testing.T) {
+	var anError = errors.New("brokers not available")
+	var cluster = &cluster{saramaConfig: createSaramaConfig()}
+	cluster.newClient = func([]string, *sarama.Config) (sarama.Client, error) {
+		return nil, anError
+	}
+	var _, err = cluster.newOffsetStore()
+	assert.Equal(t, anError, err)
+}
+
+func TestParseOffsetTime(t *testing.T) {
+	var offsetTime, ok = parseOffsetTime("2024-03-01T10:00:00Z")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), offsetTime())
+
+	offsetTime, ok = parseOffsetTime("-2h")
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(-2*time.Hour), offsetTime(), time.Minute)
+
+	for _, invalid := range []string{"2h", "yesterday", ""} {
+		_, ok = parseOffsetTime(invalid)
+		assert.False(t, ok)
+	}
+}
+
+func TestOffsetResetTarget(t *testing.T) {
+	var timestamp = time.Now().Add(-time.Hour)
+	var store = &fakeOffsetStore{byTime: map[int64]int64{timestamp.UnixMilli(): 42}, oldest: 10, newest: 100}
+
+	t.Run("To time", func(t *testing.T) {
+		var offset, ok, err = (&offsetReset{time: func() time.Time { return timestamp }}).target(store, "topic", 0, 50)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(42), offset)
+
+		// No message after this time
+		offset, ok, err = (&offsetReset{time: time.Now}).target(store, "topic", 0, 50)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(100), offset)
+	})
+	t.Run("Absolute offsets", func(t *testing.T) {
+		var reset = &offsetReset{offsets: map[int32]int64{0: 20, 1: 0, 2: 500}}
+		for partition, expected := range map[int32]int64{0: 20, 1: 10, 2: 100} {
+			var offset, ok, err = reset.target(store, "topic", partition, 50)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, expected, offset)
+		}
+		var _, ok, err = reset.target(store, "topic", 3, 50)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("Shift", func(t *testing.T) {
+		for shift, expected := range map[int64]int64{-5: 45, -100: 10, 20: 70, 100: 100} {
+			var offset, ok, err = (&offsetReset{shift: shift}).target(store, "topic", 0, 50)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, expected, offset)
+		}
+		// Nothing committed
+		var _, ok, err = (&offsetReset{shift: -5}).target(store, "topic", 0, -1)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("Offsets not available", func(t *testing.T) {
+		var _, _, err = (&offsetReset{shift: -5}).target(&fakeOffsetStore{err: errors.New("broker unavailable")}, "topic", 0, 50)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestTagMetadata(t *testing.T) {
+	assert.Equal(t, "", tagMetadata("", ""))
+	assert.Equal(t, "message", tagMetadata("", "message"))
+	assert.Equal(t, "reset-offsets:1", tagMetadata("reset-offsets:1", ""))
+	assert.Equal(t, "reset-offsets:1 message", tagMetadata("reset-offsets:1", "message"))
+	assert.Equal(t, "reset-offsets:1 message", tagMetadata("reset-offsets:1", "reset-offsets:1 message"))
+}
+
+func TestSeekClaims(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var timestamp = time.Now().Add(-2 * time.Hour)
+	var newStore = func() *fakeOffsetStore {
+		return &fakeOffsetStore{committed: map[int32]int64{0: 50, 1: -1}, metadata: map[int32]string{},
+			byTime: map[int64]int64{timestamp.UnixMilli(): 30}, oldest: 10, newest: 100}
+	}
+	var store = newStore()
+	var created = 0
+
+	var newConsumerWithStore = func(consumerRep KafkaConsumerRepresentation) *consumer {
+		var aConsumer = newConsumer(cluster, consumerRep, logger)
+		aConsumer.restartBackoff = time.Millisecond
+		aConsumer.newOffsetStore = func() (offsetStore, error) {
+			created++
+			return store, nil
+		}
+		return aConsumer
+	}
+
+	t.Run("Nothing to reset", func(t *testing.T) {
+		var aConsumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+		aConsumer.newOffsetStore = func() (offsetStore, error) { return nil, errors.New("should not be called") }
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+	})
+	t.Run("Initial offset time", func(t *testing.T) {
+		var consumerRep = createDefaultConsumerConfiguration()
+		consumerRep.InitialOffset = new(timestamp.Format(time.RFC3339Nano))
+		var aConsumer = newConsumerWithStore(consumerRep)
+		created = 0
+		// Only the partition without committed offset is positioned
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(1), int64(30), "")
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(1), int64(30), "")
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+
+		// Positioned partitions are not looked up again by the next sessions. The store is reused until the consumer is closed
+		store.failures = 1
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{1, 0}))
+		assert.Equal(t, 1, store.failures)
+		store.failures = 0
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(1), int64(30), "")
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(1), int64(30), "")
+		aConsumer.positioned = map[int32]bool{}
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+		assert.Equal(t, 1, created)
+		assert.False(t, store.closed)
+		assert.Nil(t, aConsumer.Close())
+		assert.True(t, store.closed)
+	})
+	t.Run("Configured reset is applied once", func(t *testing.T) {
+		store = newStore()
+		var consumerRep = createDefaultConsumerConfiguration()
+		consumerRep.ResetOffsets = &KafkaOffsetResetRepresentation{Shift: new(int64(-20))}
+		var aConsumer = newConsumerWithStore(consumerRep)
+		var marker = aConsumer.resetMarker()
+		assert.NotEqual(t, "", marker)
+		// Nothing to shift in partition 1
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(0), int64(30), marker)
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(30), marker)
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0}))
+
+		// The marker is kept in the committed metadata: the reset is not applied again when the consumer restarts
+		store.committed = map[int32]int64{0: 42, 1: 12}
+		store.metadata = map[int32]string{0: marker + " message", 1: marker}
+		var restarted = newConsumerWithStore(consumerRep)
+		assert.Equal(t, marker, restarted.resetMarker())
+		assert.Nil(t, restarted.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+
+		// Another configured reset is applied
+		consumerRep.ResetOffsets = &KafkaOffsetResetRepresentation{Shift: new(int64(-2))}
+		var reconfigured = newConsumerWithStore(consumerRep)
+		assert.NotEqual(t, marker, reconfigured.resetMarker())
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(0), int64(40), reconfigured.resetMarker())
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(40), reconfigured.resetMarker())
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(1), int64(10), reconfigured.resetMarker())
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(1), int64(10), reconfigured.resetMarker())
+		assert.Nil(t, reconfigured.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+	})
+	t.Run("Requested reset", func(t *testing.T) {
+		store = newStore()
+		var aConsumer = newConsumerWithStore(createDefaultConsumerConfiguration())
+		aConsumer.ShiftOffsets(-20)
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(0), int64(30), "")
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(30), "")
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0, 1}))
+
+		aConsumer.ResetOffsetsToTime(timestamp)
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(0), int64(30), "")
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(30), "")
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0}))
+	})
+	t.Run("Transient failures are retried", func(t *testing.T) {
+		var session = mock.NewConsumerGroupSession(mockCtrl)
+		session.EXPECT().Context().Return(context.TODO()).AnyTimes()
+		var aConsumer = newConsumerWithStore(createDefaultConsumerConfiguration())
+		var failures = 1
+		aConsumer.newOffsetStore = func() (offsetStore, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("brokers unavailable")
+			}
+			return store, nil
+		}
+		store.failures = 2
+		aConsumer.ResetOffsets(map[int32]int64{0: 20})
+		session.EXPECT().ResetOffset("topic", int32(0), int64(20), "")
+		session.EXPECT().MarkOffset("topic", int32(0), int64(20), "")
+		assert.Nil(t, aConsumer.seekClaims(session, []int32{0}))
+	})
+	t.Run("Failed reset is kept for the next session", func(t *testing.T) {
+		var session = mock.NewConsumerGroupSession(mockCtrl)
+		var ctx, cancel = context.WithCancel(context.TODO())
+		cancel()
+		session.EXPECT().Context().Return(ctx)
+		var aConsumer = newConsumerWithStore(createDefaultConsumerConfiguration())
+		aConsumer.newOffsetStore = func() (offsetStore, error) { return nil, errors.New("brokers unavailable") }
+		aConsumer.ResetOffsets(map[int32]int64{0: 20})
+		assert.NotNil(t, aConsumer.seekClaims(session, []int32{0}))
+
+		aConsumer.newOffsetStore = func() (offsetStore, error) { return store, nil }
+		mockConsumerGroupSession.EXPECT().ResetOffset("topic", int32(0), int64(20), "")
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), int64(20), "")
+		assert.Nil(t, aConsumer.seekClaims(mockConsumerGroupSession, []int32{0}))
+	})
+}
+
+func TestResetEndsSession(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var aConsumer = newConsumer(cluster, createDefaultConsumerConfiguration(), logger)
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Messages().Return(make(chan *sarama.ConsumerMessage))
+
+	var done = make(chan error)
+	go func() {
+		done <- aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim)
+	}()
+	aConsumer.ShiftOffsets(-10)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "claim should end when an offset reset is requested")
+	}
+
+	// The next session is not interrupted
+	var _, resetCtx = aConsumer.takeOffsetReset()
+	assert.Nil(t, resetCtx.Err())
+}
+
+func TestResettableSessionMarker(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var consumerRep = createDefaultConsumerConfiguration()
+	consumerRep.ResetOffsets = &KafkaOffsetResetRepresentation{ToTime: new("-1h")}
+	var aConsumer = newConsumer(cluster, consumerRep, logger)
+	var marker = aConsumer.resetMarker()
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var msg = &sarama.ConsumerMessage{Topic: "topic", Partition: 1, Offset: 12}
+	mockConsumerGroupSession.EXPECT().Context().Return(context.TODO())
+
+	// Offsets marked while the reset is configured keep its marker
+	var session, release = aConsumer.withResetContext(mockConsumerGroupSession)
+	defer release()
+	mockConsumerGroupSession.EXPECT().MarkMessage(msg, marker)
+	session.MarkMessage(msg, "")
+	mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(1), int64(13), marker+" message")
+	session.MarkOffset("topic", 1, 13, "message")
+}
@@ -0,0 +1,83 @@
+package kafkauniverse
+
+import (
+	"github.com/IBM/sarama"
+)
+
+// applyClusterTuning applies the tuning of a cluster to the sarama configuration shared by its producers and consumers
+func applyClusterTuning(config *sarama.Config, tuning *KafkaSaramaRepresentation) {
+	if tuning == nil {
+		return
+	}
+	if tuning.ClientID != nil {
+		config.ClientID = *tuning.ClientID
+	}
+	if tuning.DialTimeout != nil {
+		config.Net.DialTimeout = *tuning.DialTimeout
+	}
+	if tuning.ReadTimeout != nil {
+		config.Net.ReadTimeout = *tuning.ReadTimeout
+	}
+	if tuning.WriteTimeout != nil {
+		config.Net.WriteTimeout = *tuning.WriteTimeout
+	}
+	if tuning.MetadataRefreshFrequency != nil {
+		config.Metadata.RefreshFrequency = *tuning.MetadataRefreshFrequency
+	}
+	applyProducerTuning(config, tuning.Producer)
+	applyConsumerTuning(config, tuning.Consumer)
+}
+
+// applyProducerTuning applies the tuning of producers to a sarama configuration. Only the configured values are changed, so that
+// producer settings can be layered on the cluster ones
+func applyProducerTuning(config *sarama.Config, tuning *KafkaSaramaProducerRepresentation) {
+	if tuning == nil {
+		return
+	}
+	if tuning.RequiredAcks != nil {
+		config.Producer.RequiredAcks = requiredAcks[*tuning.RequiredAcks]
+	}
+	if tuning.Compression != nil {
+		config.Producer.Compression = compressionCodecs[*tuning.Compression]
+	}
+	if tuning.MaxMessageBytes != nil {
+		config.Producer.MaxMessageBytes = *tuning.MaxMessageBytes
+	}
+	if tuning.Idempotent != nil {
+		config.Producer.Idempotent = *tuning.Idempotent
+		if config.Producer.Idempotent {
+			// Settings required by sarama for idempotent producers
+			config.Producer.RequiredAcks = sarama.WaitForAll
+			config.Net.MaxOpenRequests = 1
+		}
+	}
+}
+
+// applyConsumerTuning applies the tuning of consumers to a sarama configuration. Only the configured values are changed, so that
+// consumer settings can be layered on the cluster ones
+func applyConsumerTuning(config *sarama.Config, tuning *KafkaSaramaConsumerRepresentation) {
+	if tuning == nil {
+		return
+	}
+	if tuning.SessionTimeout != nil {
+		config.Consumer.Group.Session.Timeout = *tuning.SessionTimeout
+	}
+	if tuning.HeartbeatInterval != nil {
+		config.Consumer.Group.Heartbeat.Interval = *tuning.HeartbeatInterval
+	}
+	if tuning.RebalanceTimeout != nil {
+		config.Consumer.Group.Rebalance.Timeout = *tuning.RebalanceTimeout
+	}
+	if tuning.FetchMin != nil {
+		config.Consumer.Fetch.Min = *tuning.FetchMin
+	}
+	if tuning.FetchDefault != nil {
+		config.Consumer.Fetch.Default = *tuning.FetchDefault
+	}
+	if tuning.FetchMax != nil {
+		config.Consumer.Fetch.Max = *tuning.FetchMax
+	}
+	if tuning.MaxProcessingTime != nil {
+		config.Consumer.MaxProcessingTime = *tuning.MaxProcessingTime
+	}
+}
@@ -0,0 +1,75 @@
+package kafkauniverse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyClusterTuning(t *testing.T) {
+	t.Run("No tuning", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		applyClusterTuning(config, nil)
+		assert.Equal(t, sarama.NewConfig().Net.DialTimeout, config.Net.DialTimeout)
+	})
+	t.Run("Cluster settings", func(t *testing.T) {
+		var config = sarama.NewConfig()
+		applyClusterTuning(config, &KafkaSaramaRepresentation{
+			ClientID:                 new("my-app"),
+			DialTimeout:              new(5 * time.Second),
+			ReadTimeout:              new(20 * time.Second),
+			WriteTimeout:             new(25 * time.Second),
+			MetadataRefreshFrequency: new(time.Minute),
+			Producer:                 &KafkaSaramaProducerRepresentation{RequiredAcks: new("leader"), Compression: new("lz4")},
+			Consumer:                 &KafkaSaramaConsumerRepresentation{SessionTimeout: new(30 * time.Second), FetchDefault: new(int32(2 << 20))},
+		})
+		assert.Equal(t, "my-app", config.ClientID)
+		assert.Equal(t, 5*time.Second, config.Net.DialTimeout)
+		assert.Equal(t, 20*time.Second, config.Net.ReadTimeout)
+		assert.Equal(t, 25*time.Second, config.Net.WriteTimeout)
+		assert.Equal(t, time.Minute, config.Metadata.RefreshFrequency)
+		assert.Equal(t, sarama.WaitForLocal, config.Producer.RequiredAcks)
+		assert.Equal(t, sarama.CompressionLZ4, config.Producer.Compression)
+		assert.Equal(t, 30*time.Second, config.Consumer.Group.Session.Timeout)
+		assert.Equal(t, int32(2<<20), config.Consumer.Fetch.Default)
+		assert.Nil(t, config.Validate())
+	})
+}
+
+func TestApplyProducerTuning(t *testing.T) {
+	var config = sarama.NewConfig()
+	config.Version = sarama.V3_0_0_0
+	applyProducerTuning(config, &KafkaSaramaProducerRepresentation{RequiredAcks: new("none")})
+	assert.Equal(t, sarama.NoResponse, config.Producer.RequiredAcks)
+
+	// Producer settings are layered on the cluster ones
+	applyProducerTuning(config, &KafkaSaramaProducerRepresentation{Idempotent: new(true), MaxMessageBytes: new(500000)})
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, config.Producer.RequiredAcks)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+	assert.Equal(t, 500000, config.Producer.MaxMessageBytes)
+	assert.Nil(t, config.Validate())
+}
+
+func TestApplyConsumerTuning(t *testing.T) {
+	var config = sarama.NewConfig()
+	applyConsumerTuning(config, &KafkaSaramaConsumerRepresentation{
+		SessionTimeout:    new(45 * time.Second),
+		HeartbeatInterval: new(5 * time.Second),
+		RebalanceTimeout:  new(2 * time.Minute),
+		FetchMin:          new(int32(1024)),
+		FetchDefault:      new(int32(4096)),
+		FetchMax:          new(int32(8192)),
+		MaxProcessingTime: new(time.Second),
+	})
+	assert.Equal(t, 45*time.Second, config.Consumer.Group.Session.Timeout)
+	assert.Equal(t, 5*time.Second, config.Consumer.Group.Heartbeat.Interval)
+	assert.Equal(t, 2*time.Minute, config.Consumer.Group.Rebalance.Timeout)
+	assert.Equal(t, int32(1024), config.Consumer.Fetch.Min)
+	assert.Equal(t, int32(4096), config.Consumer.Fetch.Default)
+	assert.Equal(t, int32(8192), config.Consumer.Fetch.Max)
+	assert.Equal(t, time.Second, config.Consumer.MaxProcessingTime)
+	assert.Nil(t, config.Validate())
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 )
 
 // KafkaUniverse struct
 type KafkaUniverse struct {
-	clusters  []*cluster
-	producers map[string]*producer
-	consumers map[string]*consumer
+	clusters             []*cluster
+	producers            map[string]*producer
+	consumers            map[string]*consumer
+	consumerErrorHandler KafkaConsumerErrorHandler
 }
 
 // Logger interface for logging with level
@@ -24,8 +27,36 @@ type Logger interface {
 // ConfigurationProvider interface
 type ConfigurationProvider func(target any) error
 
+// TokenMetrics receives measures about the OAuth tokens fetched to authenticate to the clusters
+type TokenMetrics interface {
+	// TokenFetched is called when a token has been obtained. Expiry is zero when the token has no known expiry
+	TokenFetched(clusterID string, latency time.Duration, expiry time.Time)
+	// TokenFetchFailed is called each time an attempt to obtain a token fails
+	TokenFetchFailed(clusterID string, latency time.Duration, err error)
+}
+
+// UniverseOption customizes the creation of a KafkaUniverse
+type UniverseOption func(*universeOptions)
+
+type universeOptions struct {
+	tokenMetrics    TokenMetrics
+	secretResolvers secretResolvers
+}
+
+// WithTokenMetrics reports measures about the OAuth tokens fetched for the clusters
+func WithTokenMetrics(metrics TokenMetrics) UniverseOption {
+	return func(options *universeOptions) {
+		options.tokenMetrics = metrics
+	}
+}
+
 // NewKafkaUniverse creates a KafkaUniverse from a provided configuration
-func NewKafkaUniverse(ctx context.Context, logger Logger, envKeyPrefix string, confUnmarshal ConfigurationProvider) (*KafkaUniverse, error) {
+func NewKafkaUniverse(ctx context.Context, logger Logger, envKeyPrefix string, confUnmarshal ConfigurationProvider, options ...UniverseOption) (*KafkaUniverse, error) {
+	var opts = universeOptions{secretResolvers: newSecretResolvers()}
+	for _, option := range options {
+		option(&opts)
+	}
+
 	var clusterRepresentations = []KafkaClusterRepresentation{}
 	var err error
 	if err = confUnmarshal(&clusterRepresentations); err != nil {
@@ -47,7 +78,7 @@ func NewKafkaUniverse(ctx context.Context, logger Logger, envKeyPrefix string, c
 		consumers: map[string]*consumer{},
 	}
 	for _, clusterRepresentation := range clusterRepresentations {
-		var cluster, err = newCluster(ctx, clusterRepresentation, envKeyPrefix, logger)
+		var cluster, err = newCluster(ctx, clusterRepresentation, envKeyPrefix, logger, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -58,11 +89,14 @@ func NewKafkaUniverse(ctx context.Context, logger Logger, envKeyPrefix string, c
 		for _, consumerRep := range clusterRepresentation.Consumers {
 			var consumer = newConsumer(cluster, consumerRep, logger)
 			res.consumers[*consumerRep.ID] = consumer
-			if consumerRep.FailureProducer != nil {
-				var ok bool
-				if consumer.failureProducer, ok = res.producers[*consumerRep.FailureProducer]; !ok {
-					return nil, fmt.Errorf("invalid failure producer %s for consumer %s", *consumerRep.FailureProducer, *consumerRep.ID)
-				}
+			if err = res.setFailureProducer(consumer); err != nil {
+				return nil, err
+			}
+			if err = res.setTransactionalProducer(consumer); err != nil {
+				return nil, err
+			}
+			if err = res.addRetryStages(consumer, consumerRep.RetryStages); err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -77,10 +111,8 @@ func (ku *KafkaUniverse) Close() error {
 			anError = err
 		}
 	}
-	for _, producer := range ku.producers {
-		if err := producer.Close(); err != nil {
-			anError = err
-		}
+	if err := ku.closeProducers(); err != nil {
+		anError = err
 	}
 	for _, cluster := range ku.clusters {
 		if err := cluster.Close(); err != nil {
@@ -90,6 +122,62 @@ func (ku *KafkaUniverse) Close() error {
 	return anError
 }
 
+// Shutdown gracefully stops all the started consumers, letting their current handler invocations finish and their marked offsets
+// be committed, then releases all instantiated resources. Producers used as failure producers, retry stages or transactional producers
+// are closed last as consumers may still use them while stopping
+func (ku *KafkaUniverse) Shutdown(ctx context.Context) error {
+	var anError error
+	var errs = make(chan error, len(ku.consumers))
+	var wg sync.WaitGroup
+	for _, consumer := range ku.consumers {
+		wg.Go(func() {
+			errs <- consumer.Stop(ctx)
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			anError = err
+		}
+	}
+	if err := ku.Close(); err != nil {
+		anError = err
+	}
+	return anError
+}
+
+func (ku *KafkaUniverse) closeProducers() error {
+	var failureProducers = map[*producer]bool{}
+	for _, aConsumer := range ku.consumers {
+		aConsumer.withStages(func(c *consumer) {
+			if c.failureProducer != nil {
+				failureProducers[c.failureProducer] = true
+			}
+			if c.retryProducer != nil {
+				failureProducers[c.retryProducer] = true
+			}
+			if c.transactionalProducer != nil {
+				failureProducers[c.transactionalProducer] = true
+			}
+		})
+	}
+	var anError error
+	var closeProducers = func(failureProducer bool) {
+		for _, producer := range ku.producers {
+			if failureProducers[producer] != failureProducer {
+				continue
+			}
+			if err := producer.Close(); err != nil {
+				anError = err
+			}
+		}
+	}
+	closeProducers(false)
+	closeProducers(true)
+	return anError
+}
+
 // InitializeProducers initializes all specified producers
 func (ku *KafkaUniverse) InitializeProducers(producerIDs ...string) error {
 	for _, producerName := range producerIDs {
@@ -128,10 +216,34 @@ func (ku *KafkaUniverse) GetConsumer(consumerID string) *consumer {
 	return ku.consumers[consumerID]
 }
 
-// StartConsumers starts all specified consumers
-func (ku *KafkaUniverse) StartConsumers(consumerIDs ...string) {
+// StartConsumers starts all specified consumers. Each consumer is supervised independently: a failing consumer applies its own
+// failure policy without stopping the other ones. Consumers stop when ctx is done
+func (ku *KafkaUniverse) StartConsumers(ctx context.Context, consumerIDs ...string) {
 	for _, consumerName := range consumerIDs {
-		ku.GetConsumer(consumerName).Go()
+		ku.GetConsumer(consumerName).Go(ctx)
+	}
+}
+
+// PauseAll pauses all the consumers of the universe, see consumer.Pause
+func (ku *KafkaUniverse) PauseAll() {
+	for _, consumer := range ku.consumers {
+		consumer.Pause()
+	}
+}
+
+// ResumeAll resumes all the consumers of the universe, see consumer.Resume
+func (ku *KafkaUniverse) ResumeAll() {
+	for _, consumer := range ku.consumers {
+		consumer.Resume()
+	}
+}
+
+// SetConsumerErrorHandler registers the handler notified when any consumer of the universe faces a fatal error, including the consumers
+// added later
+func (ku *KafkaUniverse) SetConsumerErrorHandler(handler KafkaConsumerErrorHandler) {
+	ku.consumerErrorHandler = handler
+	for _, consumer := range ku.consumers {
+		consumer.SetErrorHandler(handler)
 	}
 }
 
@@ -153,7 +265,61 @@ func (ku *KafkaUniverse) AddConsumer(clusterID string, consumerRep KafkaConsumer
 		return err
 	}
 
-	ku.consumers[*consumerRep.ID] = newConsumer(cluster, consumerRep, logger)
+	var consumer = newConsumer(cluster, consumerRep, logger)
+	if err = ku.setFailureProducer(consumer); err != nil {
+		return err
+	}
+	if err = ku.setTransactionalProducer(consumer); err != nil {
+		return err
+	}
+	if err = ku.addRetryStages(consumer, consumerRep.RetryStages); err != nil {
+		return err
+	}
+	if ku.consumerErrorHandler != nil {
+		consumer.SetErrorHandler(ku.consumerErrorHandler)
+	}
+	ku.consumers[*consumerRep.ID] = consumer
+	return nil
+}
+
+func (ku *KafkaUniverse) addRetryStages(consumer *consumer, stageReps []KafkaRetryStageRepresentation) error {
+	for _, stageRep := range stageReps {
+		var stageProducer, ok = ku.producers[*stageRep.Producer]
+		if !ok {
+			return fmt.Errorf("invalid retry stage producer %s for consumer %s", *stageRep.Producer, consumer.id)
+		}
+		consumer.addRetryStage(stageProducer, *stageRep.Delay)
+	}
+	return nil
+}
+
+func (ku *KafkaUniverse) setFailureProducer(consumer *consumer) error {
+	if consumer.failureProducerName == nil {
+		return nil
+	}
+	var failureProducer, ok = ku.producers[*consumer.failureProducerName]
+	if !ok {
+		return fmt.Errorf("invalid failure producer %s for consumer %s", *consumer.failureProducerName, consumer.id)
+	}
+	consumer.failureProducer = failureProducer
+	return nil
+}
+
+func (ku *KafkaUniverse) setTransactionalProducer(consumer *consumer) error {
+	if consumer.transactionalProducerName == nil {
+		return nil
+	}
+	var transactionalProducer, ok = ku.producers[*consumer.transactionalProducerName]
+	if !ok {
+		return fmt.Errorf("invalid transactional producer %s for consumer %s", *consumer.transactionalProducerName, consumer.id)
+	}
+	if transactionalProducer.transactionalID == nil {
+		return fmt.Errorf("producer %s of consumer %s is not transactional", transactionalProducer.id, consumer.id)
+	}
+	if transactionalProducer.cluster != consumer.cluster {
+		return fmt.Errorf("transactional producer %s and consumer %s should belong to the same cluster", transactionalProducer.id, consumer.id)
+	}
+	consumer.transactionalProducer = transactionalProducer
 	return nil
 }
 
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/cloudtrust/kafka-client/mock"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -52,6 +54,11 @@ func TestKafkaUniverse(t *testing.T) {
 		assert.Nil(t, err)
 		assert.NotNil(t, universe)
 	})
+	t.Run("With token metrics", func(t *testing.T) {
+		var universe, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", createDefaultUniverse, WithTokenMetrics(&recordedTokenMetrics{}))
+		assert.Nil(t, err)
+		assert.NotNil(t, universe)
+	})
 	t.Run("Initialize unknown producer", func(t *testing.T) {
 		var universe, _ = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", createDefaultUniverse)
 		var err = universe.InitializeProducers("unknown")
@@ -118,3 +125,229 @@ func TestAddProducerConsumer(t *testing.T) {
 		assert.NotNil(t, universe.GetConsumer("consumer1"))
 	})
 }
+
+func TestStartConsumers(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var ctx = context.TODO()
+
+	var universe, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+		var conf = target.(*[]KafkaClusterRepresentation)
+		*conf = append(*conf, createValidKafkaClusterRepresentation())
+		return nil
+	})
+	assert.Nil(t, err)
+
+	var handlerCalls = 0
+	universe.SetConsumerErrorHandler(func(ctx context.Context, consumerID string, err error) {
+		handlerCalls++
+	})
+	for _, consumer := range universe.consumers {
+		consumer.errorHandler(ctx, consumer.id, errors.New("any error"))
+	}
+	assert.Equal(t, len(universe.consumers), handlerCalls)
+
+	// The handler also applies to the consumers added later
+	assert.Nil(t, universe.AddConsumer("cluster-id", KafkaConsumerRepresentation{ID: new("consumer-added"), Topic: new("test-topic"),
+		ConsumerGroupName: new("test-consumer-group")}, logger))
+	universe.GetConsumer("consumer-added").errorHandler(ctx, "consumer-added", errors.New("any error"))
+	assert.Equal(t, len(universe.consumers), handlerCalls)
+
+	// Consumers are not initialized: starting them does nothing
+	universe.StartConsumers(ctx, "consumer-1", "consumer-2")
+	assert.Nil(t, universe.GetConsumer("consumer-1").done)
+}
+
+func TestPauseAll(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	var ctx = context.TODO()
+
+	var universe, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+		var conf = target.(*[]KafkaClusterRepresentation)
+		*conf = append(*conf, createValidKafkaClusterRepresentation())
+		return nil
+	})
+	assert.Nil(t, err)
+
+	universe.PauseAll()
+	for _, consumer := range universe.consumers {
+		assert.True(t, consumer.IsPaused())
+	}
+	universe.ResumeAll()
+	for _, consumer := range universe.consumers {
+		assert.False(t, consumer.IsPaused())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var ctx = context.TODO()
+
+	var universe, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+		var conf = target.(*[]KafkaClusterRepresentation)
+		var cluster = createValidKafkaClusterRepresentation()
+		cluster.Producers = append(cluster.Producers, KafkaProducerRepresentation{
+			ID:    new("producer-2"),
+			Topic: new("topic-producer-2"),
+		})
+		*conf = append(*conf, cluster)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	var failureProducer = mock.NewSyncProducer(mockCtrl)
+	var otherProducer = mock.NewSyncProducer(mockCtrl)
+	for id, producer := range universe.producers {
+		producer.initialized = true
+		producer.producer = otherProducer
+		if id == "producer-1" {
+			producer.producer = failureProducer
+		}
+	}
+
+	// Failure producers are closed after the other ones
+	gomock.InOrder(
+		otherProducer.EXPECT().Close().Return(nil),
+		failureProducer.EXPECT().Close().Return(nil),
+	)
+	assert.Nil(t, universe.Shutdown(ctx))
+}
+
+func TestRetryStages(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var ctx = context.TODO()
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	t.Run("Unknown retry stage producer", func(t *testing.T) {
+		var _, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+			var conf = target.(*[]KafkaClusterRepresentation)
+			var cluster = createValidKafkaClusterRepresentation()
+			cluster.Consumers[1].RetryStages[0].Producer = new("unknown")
+			*conf = append(*conf, cluster)
+			return nil
+		})
+		assert.NotNil(t, err)
+	})
+	t.Run("Success", func(t *testing.T) {
+		var universe, err = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+			var conf = target.(*[]KafkaClusterRepresentation)
+			*conf = append(*conf, createValidKafkaClusterRepresentation())
+			return nil
+		})
+		assert.Nil(t, err)
+		var consumer = universe.GetConsumer("consumer-2")
+		assert.Len(t, consumer.retryStages, 1)
+		assert.Equal(t, "topic-producer-1", consumer.retryStages[0].topic)
+		assert.Equal(t, consumer.failureProducer, consumer.retryStages[0].failureProducer)
+	})
+	t.Run("Add consumer with failure producer", func(t *testing.T) {
+		var universe, _ = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+			var conf = target.(*[]KafkaClusterRepresentation)
+			*conf = append(*conf, createValidKafkaClusterRepresentation())
+			return nil
+		})
+		var err = universe.AddConsumer("cluster-id", KafkaConsumerRepresentation{
+			ID:                new("consumer3"),
+			Topic:             new("test-topic"),
+			ConsumerGroupName: new("test-consumer-group"),
+			FailureProducer:   new("producer-1"),
+			RetryStages:       []KafkaRetryStageRepresentation{{Producer: new("producer-1"), Delay: new(time.Second)}},
+		}, logger)
+		assert.Nil(t, err)
+		var consumer = universe.GetConsumer("consumer3")
+		assert.Equal(t, universe.GetProducer("producer-1"), consumer.failureProducer)
+		assert.Len(t, consumer.retryStages, 1)
+		assert.Equal(t, consumer.failureProducer, consumer.retryStages[0].failureProducer)
+
+		err = universe.AddConsumer("cluster-id", KafkaConsumerRepresentation{
+			ID:                new("consumer4"),
+			Topic:             new("test-topic"),
+			ConsumerGroupName: new("test-consumer-group"),
+			FailureProducer:   new("unknown"),
+		}, logger)
+		assert.NotNil(t, err)
+		assert.Nil(t, universe.GetConsumer("consumer4"))
+	})
+	t.Run("Add consumer with unknown retry stage producer", func(t *testing.T) {
+		var universe, _ = NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+			var conf = target.(*[]KafkaClusterRepresentation)
+			*conf = append(*conf, createValidKafkaClusterRepresentation())
+			return nil
+		})
+		var err = universe.AddConsumer("cluster-id", KafkaConsumerRepresentation{
+			ID:                new("consumer3"),
+			Topic:             new("test-topic"),
+			ConsumerGroupName: new("test-consumer-group"),
+			RetryStages:       []KafkaRetryStageRepresentation{{Producer: new("unknown"), Delay: new(time.Second)}},
+		}, logger)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestTransactionalProducer(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var ctx = context.TODO()
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	var newUniverse = func(update func(cluster *KafkaClusterRepresentation)) (*KafkaUniverse, error) {
+		return NewKafkaUniverse(ctx, logger, "CT_KAFKA_CLIENT_SECRET_", func(target any) error {
+			var conf = target.(*[]KafkaClusterRepresentation)
+			var cluster = createValidKafkaClusterRepresentation()
+			update(&cluster)
+			*conf = append(*conf, cluster)
+			return nil
+		})
+	}
+
+	t.Run("Unknown transactional producer", func(t *testing.T) {
+		var _, err = newUniverse(func(cluster *KafkaClusterRepresentation) {
+			cluster.Consumers[0].TransactionalProducer = new("unknown")
+		})
+		assert.NotNil(t, err)
+	})
+	t.Run("Producer is not transactional", func(t *testing.T) {
+		var _, err = newUniverse(func(cluster *KafkaClusterRepresentation) {
+			cluster.Consumers[0].TransactionalProducer = new("producer-1")
+		})
+		assert.NotNil(t, err)
+	})
+	t.Run("Success", func(t *testing.T) {
+		var universe, err = newUniverse(func(cluster *KafkaClusterRepresentation) {
+			cluster.Producers[0].TransactionalID = new("transactional-id")
+			cluster.Consumers[1].TransactionalProducer = new("producer-1")
+		})
+		assert.Nil(t, err)
+		var consumer = universe.GetConsumer("consumer-2")
+		assert.Equal(t, universe.GetProducer("producer-1"), consumer.transactionalProducer)
+		assert.Equal(t, consumer.transactionalProducer, consumer.retryStages[0].transactionalProducer)
+		assert.Equal(t, sarama.ReadCommitted, consumer.isolationLevel)
+	})
+	t.Run("Add consumer with producer of another cluster", func(t *testing.T) {
+		var universe, _ = newUniverse(func(cluster *KafkaClusterRepresentation) {
+			cluster.Producers[0].TransactionalID = new("transactional-id")
+		})
+		universe.GetProducer("producer-1").cluster = &cluster{}
+		var err = universe.AddConsumer("cluster-id", KafkaConsumerRepresentation{
+			ID:                    new("consumer3"),
+			Topic:                 new("test-topic"),
+			ConsumerGroupName:     new("test-consumer-group"),
+			TransactionalProducer: new("producer-1"),
+		}, logger)
+		assert.NotNil(t, err)
+	})
+}
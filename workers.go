@@ -0,0 +1,160 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+const defaultMaxInFlightPerWorker = 10
+
+// errSessionEnded is returned when a message can't be processed because the session of the consumer group ended
+var errSessionEnded = errors.New("consumer group session ended")
+
+// claimOffsets keeps track of the messages of a claim processed concurrently. Offsets are only marked up to the lowest contiguous
+// committed message, so that no message is skipped if the consumer stops before the messages consumed earlier are processed
+type claimOffsets struct {
+	mutex     sync.Mutex
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	// pending lists the offsets of the dispatched messages which are not marked yet, in consumption order
+	pending   []int64
+	committed map[int64]string
+}
+
+func newClaimOffsets(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) *claimOffsets {
+	return &claimOffsets{
+		session:   session,
+		topic:     claim.Topic(),
+		partition: claim.Partition(),
+		committed: map[int64]string{},
+	}
+}
+
+// dispatched registers a message before it is processed
+func (o *claimOffsets) dispatched(offset int64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.pending = append(o.pending, offset)
+}
+
+// commit confirms that the message at the given offset has been processed and marks the offsets which are now contiguous. Offsets which
+// are not pending (already marked or never dispatched) are ignored
+func (o *claimOffsets) commit(offset int64, metadata string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !slices.Contains(o.pending, offset) {
+		return
+	}
+	o.committed[offset] = metadata
+
+	var marked = -1
+	for marked+1 < len(o.pending) {
+		if _, ok := o.committed[o.pending[marked+1]]; !ok {
+			break
+		}
+		marked++
+	}
+	if marked < 0 {
+		return
+	}
+	var last = o.pending[marked]
+	metadata = o.committed[last]
+	for _, done := range o.pending[:marked+1] {
+		delete(o.committed, done)
+	}
+	o.pending = o.pending[marked+1:]
+	o.session.MarkOffset(o.topic, o.partition, last+1, metadata)
+}
+
+type dispatchedMessage struct {
+	ctx context.Context
+	msg *sarama.ConsumerMessage
+}
+
+// workerPool processes the messages of a claim concurrently. Messages with the same key are processed by the same worker, in
+// consumption order
+type workerPool struct {
+	consumer *consumer
+	session  sarama.ConsumerGroupSession
+	claim    sarama.ConsumerGroupClaim
+	offsets  *claimOffsets
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	queues   []chan dispatchedMessage
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newWorkerPool(c *consumer, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) *workerPool {
+	var pool = &workerPool{
+		consumer: c,
+		session:  session,
+		claim:    claim,
+		offsets:  newClaimOffsets(session, claim),
+		queues:   make([]chan dispatchedMessage, c.workers),
+		inFlight: make(chan struct{}, c.maxInFlight),
+	}
+	pool.ctx, pool.cancel = context.WithCancelCause(session.Context())
+	for idx := range pool.queues {
+		pool.queues[idx] = make(chan dispatchedMessage, c.maxInFlight)
+		pool.wg.Go(func() {
+			pool.work(pool.queues[idx])
+		})
+	}
+	return pool
+}
+
+// dispatch sends a message to its worker once the number of messages in flight allows it. It returns false if the pool is stopping
+func (p *workerPool) dispatch(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	select {
+	case <-p.ctx.Done():
+		return false
+	case p.inFlight <- struct{}{}:
+	}
+	p.offsets.dispatched(msg.Offset)
+	p.queues[p.worker(msg)] <- dispatchedMessage{ctx: ctx, msg: msg}
+	return true
+}
+
+// worker selects the worker of a message from its key. Messages without key are spread over all workers
+func (p *workerPool) worker(msg *sarama.ConsumerMessage) int {
+	if msg.Key == nil {
+		return int(msg.Offset % int64(len(p.queues)))
+	}
+	var hash = fnv.New32a()
+	_, _ = hash.Write(msg.Key)
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *workerPool) work(queue chan dispatchedMessage) {
+	for dispatched := range queue {
+		// Once the pool is stopping, the remaining messages are left unmarked and will be consumed again by the next session
+		if p.ctx.Err() == nil && p.consumer.waitWhilePaused(p.ctx, dispatched.msg.Partition) {
+			if err := p.consumer.processMessage(dispatched.ctx, p.session, p.claim, dispatched.msg, p.offsets); err != nil && !errors.Is(err, errSessionEnded) {
+				p.cancel(err)
+			}
+		}
+		<-p.inFlight
+	}
+}
+
+// close waits until the messages in flight are processed and returns the error which stopped the pool, if any
+func (p *workerPool) close() error {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+	var err = context.Cause(p.ctx)
+	p.cancel(nil)
+	if err == context.Cause(p.session.Context()) {
+		// Stopped by the end of the session
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package kafkauniverse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/cloudtrust/kafka-client/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestClaimOffsets(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic")
+	mockConsumerGroupClaim.EXPECT().Partition().Return(int32(2))
+
+	var offsets = newClaimOffsets(mockConsumerGroupSession, mockConsumerGroupClaim)
+	// Offset 3 is missing, like after a compaction
+	for _, offset := range []int64{1, 2, 4, 5} {
+		offsets.dispatched(offset)
+	}
+
+	// Offset 1 is not processed yet
+	offsets.commit(2, "")
+	offsets.commit(5, "")
+
+	mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(2), int64(3), "")
+	offsets.commit(1, "")
+
+	mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(2), int64(6), "")
+	offsets.commit(4, "metadata")
+	assert.Empty(t, offsets.pending)
+	assert.Empty(t, offsets.committed)
+
+	// Committing twice or committing an offset which was never dispatched doesn't leak
+	offsets.dispatched(6)
+	offsets.commit(4, "")
+	offsets.commit(7, "")
+	assert.Empty(t, offsets.committed)
+	mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(2), int64(7), "")
+	offsets.commit(6, "")
+	offsets.commit(6, "")
+	assert.Empty(t, offsets.pending)
+	assert.Empty(t, offsets.committed)
+}
+
+func TestConsumeClaimConcurrently(t *testing.T) {
+	var mockCtrl = gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var logger = mock.NewLogger(mockCtrl)
+	var cluster = &cluster{
+		logger:       logger,
+		saramaConfig: createSaramaConfig(),
+	}
+	var consumerRep = createDefaultConsumerConfiguration()
+	var sequential = newConsumer(cluster, consumerRep, logger)
+	assert.Equal(t, 1, sequential.workers)
+	assert.Equal(t, defaultMaxInFlightPerWorker, sequential.maxInFlight)
+
+	consumerRep.Workers = new(3)
+	consumerRep.MaxInFlight = new(4)
+	var aConsumer = newConsumer(cluster, consumerRep, logger)
+
+	var mockConsumerGroupClaim = mock.NewConsumerGroupClaim(mockCtrl)
+	logger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Topic().Return("topic").AnyTimes()
+	mockConsumerGroupClaim.EXPECT().Partition().Return(int32(0)).AnyTimes()
+
+	var keys = []string{"a", "b", "a", "c", "b", "a", "", "c"}
+	var newMessages = func() chan *sarama.ConsumerMessage {
+		var messages = make(chan *sarama.ConsumerMessage, len(keys))
+		for idx, key := range keys {
+			var msg = &sarama.ConsumerMessage{Topic: "topic", Offset: int64(idx + 1), Timestamp: time.Now(), Value: []byte(key)}
+			if key != "" {
+				msg.Key = []byte(key)
+			}
+			messages <- msg
+		}
+		close(messages)
+		return messages
+	}
+
+	t.Run("Ordered by key", func(t *testing.T) {
+		var mutex sync.Mutex
+		var processed = map[string][]int64{}
+		var lastMarked int64
+		var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+		mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+		aConsumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			if msg.GetOffset() == 1 {
+				// Slow first message: the following messages of other keys are processed meanwhile
+				time.Sleep(20 * time.Millisecond)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			processed[string(msg.GetKey())] = append(processed[string(msg.GetKey())], msg.GetOffset())
+			return nil
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages())
+		mockConsumerGroupSession.EXPECT().MarkOffset("topic", int32(0), gomock.Any(), "").DoAndReturn(func(_ string, _ int32, offset int64, _ string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Greater(t, offset, lastMarked)
+			lastMarked = offset
+		}).MinTimes(1)
+
+		assert.Nil(t, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+		assert.Equal(t, []int64{1, 3, 6}, processed["a"])
+		assert.Equal(t, []int64{2, 5}, processed["b"])
+		assert.Equal(t, []int64{4, 8}, processed["c"])
+		assert.Equal(t, int64(len(keys)+1), lastMarked)
+	})
+	t.Run("Abort consuming", func(t *testing.T) {
+		var handlerError = errors.New("error from handler")
+		var mockConsumerGroupSession = mock.NewConsumerGroupSession(mockCtrl)
+		mockConsumerGroupSession.EXPECT().Context().Return(context.TODO()).AnyTimes()
+		aConsumer.SetHandler(func(ctx context.Context, msg KafkaMessage) error {
+			if msg.GetOffset() == 1 {
+				msg.AbortConsuming()
+				return handlerError
+			}
+			return nil
+		})
+		mockConsumerGroupClaim.EXPECT().Messages().Return(newMessages())
+
+		// The first message is not processed: no offset can be marked
+		assert.Equal(t, handlerError, aConsumer.ConsumeClaim(mockConsumerGroupSession, mockConsumerGroupClaim))
+	})
+}